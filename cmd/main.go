@@ -2,48 +2,82 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"expvar"
 	"flag"
-	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
-	"text/template"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
+	"webrtc-app/internal/cluster"
+	"webrtc-app/internal/config"
 	hand "webrtc-app/internal/handlers"
+	"webrtc-app/internal/logger"
+	"webrtc-app/internal/static"
+	"webrtc-app/internal/tlscert"
+	"webrtc-app/pkg/postgres"
 
-	"github.com/pion/logging"
+	"github.com/jackc/pgx/v5/pgxpool"
 	// verifytoken "webrtc-app/test-verify-token"
 )
 
 var (
 	// indexTemplate = &template.Template{}
-	log = logging.NewDefaultLoggerFactory().NewLogger("sfu-ws")
+
+	configPath = flag.String("config", os.Getenv("CONFIG_PATH"), "path to the YAML config file, environment variables override it")
 )
 
 func main() {
 	flag.Parse()
 
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fatal("Invalid config", err)
+	}
+
+	// Логи узла, кластера и pion в одном формате (LOG_LEVEL, LOG_FORMAT)
+	slog.SetDefault(logger.New(cfg.Log))
+
 	// Контекст для graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Загрузка статики
-	indexHTML, err := os.ReadFile("internal/static/index.html")
-	if err != nil {
-		fmt.Printf("Failed to read index.html: %v", err)
+	// Режим кластера: узел регистрируется в общем реестре и получает комнаты, которые создаёт
+	var (
+		node *cluster.Cluster
+		db   *pgxpool.Pool
+	)
+	if cfg.Cluster.Registry != "" {
+		if node, db, err = joinCluster(ctx, cfg); err != nil {
+			fatal("Failed to join cluster", err)
+		}
 	}
 
-	styleCSS, err := os.ReadFile("internal/static/style.css")
+	srv, err := hand.NewServer(cfg, node, db)
 	if err != nil {
-		fmt.Printf("Failed to read style.css: %v", err)
+		fatal("Invalid WebRTC settings", err)
 	}
+	defer srv.Close()
 
-	scriptJS, err := os.ReadFile("internal/static/script.js")
+	// Встроенный TURN-сервер (включается TURN_LISTEN)
+	turnServer, err := srv.StartTURNServer()
 	if err != nil {
-		fmt.Printf("Failed to read script.js: %v", err)
+		fatal("Failed to start TURN server", err)
+	}
+	if turnServer != nil {
+		defer turnServer.Close()
 	}
 
-	indexTemplate := template.Must(template.New("").Parse(string(indexHTML)))
+	// Веб-клиент встроен в бинарник, HTTP_STATIC_DIR переопределяет его файлы
+	assets, err := static.Load(cfg.HTTP.StaticDir)
+	if err != nil {
+		fatal("Failed to load static assets", err)
+	}
 
 	// Общий тикер
 	go func() {
@@ -53,11 +87,7 @@ func main() {
 		for {
 			select {
 			case <-ticker.C:
-				hand.RoomsLock.RLock()
-				for _, room := range hand.Rooms {
-					room.DispatchKeyFrame()
-				}
-				hand.RoomsLock.RUnlock()
+				srv.DispatchKeyFrames()
 			case <-ctx.Done():
 				return
 			}
@@ -66,51 +96,195 @@ func main() {
 
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/api/create-room", hand.EnableCORS(hand.CreateRoomHandler))
-	mux.HandleFunc("/api/check-room", hand.EnableCORS(hand.CheckRoomHandler))
-	mux.HandleFunc("/websocket", hand.EnableCORS(hand.WebsocketHandler))
+	mux.HandleFunc("/api/create-room", srv.EnableCORS(srv.CreateRoomHandler))
+	mux.HandleFunc("/api/check-room", srv.EnableCORS(srv.CheckRoomHandler))
+	mux.HandleFunc("/websocket", srv.EnableCORS(srv.WebsocketHandler))
+	mux.HandleFunc("/whip/{room}", srv.EnableCORS(srv.WHIPHandler))
+	mux.HandleFunc("/whip/{room}/{session}", srv.EnableCORS(srv.WHIPResourceHandler))
+	mux.HandleFunc("/whep/{room}", srv.EnableCORS(srv.WHEPHandler))
+	mux.HandleFunc("/whep/{room}/{session}", srv.EnableCORS(srv.WHEPResourceHandler))
+	mux.HandleFunc("/relay/{room}", srv.RelayHandler)
+	mux.HandleFunc("/api/relay", srv.StartRelayHandler)
 
-	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/css")
-		w.Write(styleCSS)
-	})
+	// Проверки для оркестратора
+	mux.HandleFunc("/healthz", srv.HealthzHandler)
+	mux.HandleFunc("/readyz", srv.ReadyzHandler)
 
-	mux.HandleFunc("/script.js", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/javascript")
-		w.Write(scriptJS)
-	})
+	mux.HandleFunc("/", srv.EnableCORS(assets.Handler(func(r *http.Request) any {
+		return srv.WebsocketURL(r)
+	})))
+
+	server := &http.Server{
+		Addr:    cfg.HTTP.Addr,
+		Handler: logger.RequestID(mux),
+	}
 
-	mux.HandleFunc("/", hand.EnableCORS(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path != "/" {
-			http.NotFound(w, r)
-			return
+	// HTTPS: сертификат перечитывается при изменении файлов без перезапуска
+	if cfg.TLS.CertFile != "" {
+		certificate, err := tlscert.New(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			fatal("Failed to load TLS certificate", err)
 		}
+		go certificate.Run(ctx, cfg.TLS.ReloadInterval)
 
-		if err := indexTemplate.Execute(w, "ws://"+r.Host+"/websocket"); err != nil {
-			log.Errorf("Failed to execute template: %v", err)
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		server.TLSConfig = &tls.Config{
+			GetCertificate: certificate.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
 		}
-	}))
+	}
 
-	server := &http.Server{
-		Addr:    *hand.Addr,
-		Handler: mux,
+	// Перенаправление с HTTP на HTTPS (TLS_REDIRECT_ADDR)
+	var redirectServer *http.Server
+	if cfg.TLS.RedirectAddr != "" {
+		redirectServer = &http.Server{
+			Addr:    cfg.TLS.RedirectAddr,
+			Handler: redirectToHTTPS(cfg.HTTP.Addr),
+		}
+
+		go func() {
+			slog.Info("HTTPS redirect listening", "addr", cfg.TLS.RedirectAddr)
+			if err := redirectServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("HTTPS redirect server failed", "err", err)
+			}
+		}()
 	}
 
+	// Служебные страницы не видны клиентам: отдельный листенер HTTP_ADMIN_ADDR, по умолчанию только localhost
+	var adminServer *http.Server
+	if cfg.HTTP.AdminAddr != "" {
+		adminMux := http.NewServeMux()
+		adminMux.HandleFunc("/debug/status", srv.StatusHandler)
+
+		// Счётчики сервера (перезапуски ICE и т.п.)
+		expvar.Publish("ice_restarts", srv.ICERestarts())
+		adminMux.Handle("/debug/vars", expvar.Handler())
+
+		adminServer = &http.Server{
+			Addr:    cfg.HTTP.AdminAddr,
+			Handler: adminMux,
+		}
+
+		go func() {
+			slog.Info("Admin server listening", "addr", cfg.HTTP.AdminAddr)
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("Admin server failed", "err", err)
+			}
+		}()
+	}
+
+	// По SIGTERM/SIGINT узел перестаёт принимать участников и ждёт, пока комнаты опустеют.
+	// Websocket-соединения захвачены и Shutdown их не ждёт, поэтому сначала закрываются сами звонки.
 	go func() {
-		<-ctx.Done()
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+
+		select {
+		case sig := <-signals:
+			slog.Info("Received signal, draining", "signal", sig.String())
+
+			// Повторный сигнал прерывает ожидание: оставшиеся звонки закрываются сразу
+			drainCtx, drainCancel := context.WithCancel(context.Background())
+			go func() {
+				select {
+				case sig := <-signals:
+					slog.Warn("Received second signal, closing remaining connections", "signal", sig.String())
+					drainCancel()
+				case <-drainCtx.Done():
+				}
+			}()
+
+			srv.Drain(drainCtx)
+			drainCancel()
+			cancel()
+		case <-ctx.Done():
+		}
+
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer shutdownCancel()
 
+		if redirectServer != nil {
+			redirectServer.Shutdown(shutdownCtx)
+		}
+		if adminServer != nil {
+			adminServer.Shutdown(shutdownCtx)
+		}
+
 		if err := server.Shutdown(shutdownCtx); err != nil {
-			fmt.Printf("HTTP server shutdown error: %v", err)
+			slog.Error("HTTP server shutdown error", "err", err)
 		}
 	}()
 
-	log.Infof("Server starting on %s", *hand.Addr)
-	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-		fmt.Printf("HTTP server failed: %v", err)
+	slog.Info("Server starting", "addr", cfg.HTTP.Addr, "tls", server.TLSConfig != nil)
+	if server.TLSConfig != nil {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		slog.Error("HTTP server failed", "err", err)
+	}
+
+	slog.Info("Server stopped gracefully")
+}
+
+// fatal пишет ошибку запуска и завершает процесс
+func fatal(msg string, err error) {
+	slog.Error(msg, "err", err)
+	os.Exit(1)
+}
+
+// redirectToHTTPS перенаправляет запросы на тот же хост и путь по HTTPS.
+// Порт берётся из адреса HTTPS-листенера, стандартный 443 опускается.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// 308 сохраняет метод и тело, чтобы POST к API не превратился в GET
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// joinCluster подключает узел к реестру кластера и запускает продление регистрации.
+// Для реестра postgres возвращает и пул соединений.
+func joinCluster(ctx context.Context, cfg *config.Config) (*cluster.Cluster, *pgxpool.Pool, error) {
+	var (
+		store cluster.Store
+		pool  *pgxpool.Pool
+		err   error
+	)
+	switch cfg.Cluster.Registry {
+	case "postgres":
+		if pool, err = postgres.New(ctx, cfg.Postgres); err != nil {
+			return nil, nil, err
+		}
+		store = cluster.NewPostgresStore(pool)
 	}
 
-	log.Info("Server stopped gracefully")
+	id := cfg.Cluster.NodeID
+	if id == "" {
+		if id, err = os.Hostname(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	node := cluster.New(store, cluster.Node{ID: id, Address: cfg.Cluster.NodeAddress})
+	if err := node.Join(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	go func() {
+		if err := node.Run(ctx); err != nil {
+			slog.Error("Failed to leave cluster", "err", err)
+		}
+	}()
+
+	return node, pool, nil
 }
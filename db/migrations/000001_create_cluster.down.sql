@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS cluster_rooms;
+DROP TABLE IF EXISTS cluster_nodes;
@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS cluster_nodes (
+    id           TEXT PRIMARY KEY,
+    address      TEXT        NOT NULL,
+    heartbeat_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS cluster_rooms (
+    name       TEXT PRIMARY KEY,
+    node_id    TEXT        NOT NULL REFERENCES cluster_nodes (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HeartbeatInterval — период, с которым узел подтверждает, что жив
+	HeartbeatInterval = 5 * time.Second
+	// NodeTTL — узел без подтверждения дольше этого времени считается выбывшим, его комнаты можно занять
+	NodeTTL = 3 * HeartbeatInterval
+)
+
+// Node — узел кластера SFU. Address — базовый URL, по которому узел доступен другим узлам и клиентам.
+type Node struct {
+	ID          string
+	Address     string
+	HeartbeatAt time.Time
+}
+
+// Store — общий реестр узлов и владельцев комнат.
+// Каждая комната принадлежит одному живому узлу; комнату выбывшего узла может занять другой.
+type Store interface {
+	// Heartbeat регистрирует узел или продлевает его регистрацию
+	Heartbeat(ctx context.Context, node Node) error
+	// Leave удаляет узел вместе с его комнатами
+	Leave(ctx context.Context, nodeID string) error
+	// ClaimRoom закрепляет комнату за узлом, если она свободна, и возвращает её владельца
+	ClaimRoom(ctx context.Context, room, nodeID string) (Node, error)
+	// RoomOwner возвращает живого владельца комнаты
+	RoomOwner(ctx context.Context, room string) (Node, bool, error)
+}
+
+// Cluster — участие текущего узла в кластере
+type Cluster struct {
+	Self  Node
+	store Store
+	left  atomic.Bool
+}
+
+func New(store Store, self Node) *Cluster {
+	return &Cluster{Self: self, store: store}
+}
+
+// Join регистрирует узел в кластере. Вызывается до приёма запросов.
+func (c *Cluster) Join(ctx context.Context) error {
+	if err := c.store.Heartbeat(ctx, c.Self); err != nil {
+		return err
+	}
+
+	slog.Info("Node joined cluster", "node", c.Self.ID, "address", c.Self.Address)
+
+	return nil
+}
+
+// Run продлевает регистрацию узла до отмены ctx, после чего выводит узел из кластера
+func (c *Cluster) Run(ctx context.Context) error {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if c.left.Load() {
+				continue
+			}
+			if err := c.store.Heartbeat(ctx, c.Self); err != nil {
+				slog.Error("Failed to send heartbeat", "node", c.Self.ID, "err", err)
+			}
+		case <-ctx.Done():
+			leaveCtx, cancel := context.WithTimeout(context.Background(), HeartbeatInterval)
+			defer cancel()
+
+			return c.store.Leave(leaveCtx, c.Self.ID)
+		}
+	}
+}
+
+// Leave выводит узел из кластера до остановки Run, его комнаты сразу можно занять на других узлах
+func (c *Cluster) Leave(ctx context.Context) error {
+	c.left.Store(true)
+
+	return c.store.Leave(ctx, c.Self.ID)
+}
+
+// ClaimRoom закрепляет комнату за текущим узлом. local — false, если комната уже принадлежит другому узлу.
+func (c *Cluster) ClaimRoom(ctx context.Context, room string) (owner Node, local bool, err error) {
+	owner, err = c.store.ClaimRoom(ctx, room, c.Self.ID)
+	if err != nil {
+		return Node{}, false, err
+	}
+
+	return owner, owner.ID == c.Self.ID, nil
+}
+
+// RoomOwner возвращает узел, которому принадлежит комната
+func (c *Cluster) RoomOwner(ctx context.Context, room string) (Node, bool, error) {
+	return c.store.RoomOwner(ctx, room)
+}
@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestNodes регистрирует в общем реестре в памяти узлы с заданными ID
+func newTestNodes(t *testing.T, store *MemoryStore, ids ...string) []*Cluster {
+	t.Helper()
+
+	nodes := make([]*Cluster, 0, len(ids))
+	for _, id := range ids {
+		node := New(store, Node{ID: id, Address: "http://" + id})
+		if err := node.Join(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+func TestClaimRoom(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestNodes(t, NewMemoryStore(), "a", "b")
+
+	owner, local, err := nodes[0].ClaimRoom(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !local || owner.ID != "a" {
+		t.Fatalf("first claim: owner %q, local %v", owner.ID, local)
+	}
+
+	// Занятую комнату другой узел не получает, но узнаёт её владельца
+	owner, local, err = nodes[1].ClaimRoom(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local || owner.ID != "a" || owner.Address != "http://a" {
+		t.Fatalf("second claim: owner %+v, local %v", owner, local)
+	}
+
+	// Повторный захват своей комнаты не меняет владельца
+	if _, local, _ = nodes[0].ClaimRoom(ctx, "room"); !local {
+		t.Fatal("owner lost its room on a repeated claim")
+	}
+}
+
+func TestRoomOwner(t *testing.T) {
+	ctx := context.Background()
+	nodes := newTestNodes(t, NewMemoryStore(), "a", "b")
+
+	if _, ok, err := nodes[1].RoomOwner(ctx, "room"); err != nil || ok {
+		t.Fatalf("unclaimed room has an owner: ok %v, err %v", ok, err)
+	}
+
+	if _, _, err := nodes[0].ClaimRoom(ctx, "room"); err != nil {
+		t.Fatal(err)
+	}
+
+	owner, ok, err := nodes[1].RoomOwner(ctx, "room")
+	if err != nil || !ok || owner.ID != "a" {
+		t.Fatalf("owner %q, ok %v, err %v", owner.ID, ok, err)
+	}
+
+	// Вышедший узел освобождает свои комнаты сразу
+	if err := nodes[0].Leave(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := nodes[1].RoomOwner(ctx, "room"); ok {
+		t.Fatal("room still owned by a node that left")
+	}
+}
+
+// Комнату узла, переставшего подтверждать, что жив, через NodeTTL занимает другой узел
+func TestClaimRoomTakeoverAfterNodeTTL(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	nodes := newTestNodes(t, store, "a", "b")
+
+	if _, _, err := nodes[0].ClaimRoom(ctx, "room"); err != nil {
+		t.Fatal(err)
+	}
+
+	store.mu.Lock()
+	stale := store.nodes["a"]
+	stale.HeartbeatAt = time.Now().Add(-NodeTTL + time.Second)
+	store.nodes["a"] = stale
+	store.mu.Unlock()
+
+	if _, local, _ := nodes[1].ClaimRoom(ctx, "room"); local {
+		t.Fatal("room taken over before NodeTTL expired")
+	}
+
+	store.mu.Lock()
+	stale.HeartbeatAt = time.Now().Add(-NodeTTL - time.Second)
+	store.nodes["a"] = stale
+	store.mu.Unlock()
+
+	if _, ok, _ := nodes[1].RoomOwner(ctx, "room"); ok {
+		t.Fatal("expired node still owns the room")
+	}
+
+	owner, local, err := nodes[1].ClaimRoom(ctx, "room")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !local || owner.ID != "b" {
+		t.Fatalf("takeover: owner %q, local %v", owner.ID, local)
+	}
+
+	// Вернувшийся узел комнату обратно не получает
+	if err := store.Heartbeat(ctx, nodes[0].Self); err != nil {
+		t.Fatal(err)
+	}
+	if _, local, _ := nodes[0].ClaimRoom(ctx, "room"); local {
+		t.Fatal("returning node reclaimed a room that was taken over")
+	}
+}
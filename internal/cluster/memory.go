@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore — реестр в памяти процесса для тестов, где несколько узлов запускаются в одном процессе
+type MemoryStore struct {
+	mu    sync.Mutex
+	nodes map[string]Node
+	rooms map[string]string // Комната → ID узла
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes: make(map[string]Node),
+		rooms: make(map[string]string),
+	}
+}
+
+func (s *MemoryStore) Heartbeat(_ context.Context, node Node) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node.HeartbeatAt = time.Now()
+	s.nodes[node.ID] = node
+
+	return nil
+}
+
+func (s *MemoryStore) Leave(_ context.Context, nodeID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.nodes, nodeID)
+	for room, owner := range s.rooms {
+		if owner == nodeID {
+			delete(s.rooms, room)
+		}
+	}
+
+	return nil
+}
+
+func (s *MemoryStore) ClaimRoom(_ context.Context, room, nodeID string) (Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if owner, ok := s.liveOwner(room); ok {
+		return owner, nil
+	}
+
+	s.rooms[room] = nodeID
+
+	return s.nodes[nodeID], nil
+}
+
+func (s *MemoryStore) RoomOwner(_ context.Context, room string) (Node, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	owner, ok := s.liveOwner(room)
+
+	return owner, ok, nil
+}
+
+// liveOwner возвращает владельца комнаты, если он ещё подтверждает, что жив. Вызывается под mu.
+func (s *MemoryStore) liveOwner(room string) (Node, bool) {
+	nodeID, ok := s.rooms[room]
+	if !ok {
+		return Node{}, false
+	}
+
+	node, ok := s.nodes[nodeID]
+	if !ok || time.Since(node.HeartbeatAt) > NodeTTL {
+		return Node{}, false
+	}
+
+	return node, true
+}
@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var errOwnerNotAlive = errors.New("room owner is not alive")
+
+// PostgresStore — реестр кластера в Postgres (таблицы cluster_nodes и cluster_rooms из db/migrations)
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+func (s *PostgresStore) Heartbeat(ctx context.Context, node Node) error {
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO cluster_nodes (id, address, heartbeat_at) VALUES ($1, $2, now())
+		ON CONFLICT (id) DO UPDATE SET address = EXCLUDED.address, heartbeat_at = now()`,
+		node.ID, node.Address)
+
+	return err
+}
+
+func (s *PostgresStore) Leave(ctx context.Context, nodeID string) error {
+	// Комнаты узла удаляются каскадно
+	_, err := s.pool.Exec(ctx, `DELETE FROM cluster_nodes WHERE id = $1`, nodeID)
+
+	return err
+}
+
+func (s *PostgresStore) ClaimRoom(ctx context.Context, room, nodeID string) (Node, error) {
+	// Комнату выбывшего узла можно занять заново
+	_, err := s.pool.Exec(ctx, `
+		INSERT INTO cluster_rooms (name, node_id) VALUES ($1, $2)
+		ON CONFLICT (name) DO UPDATE SET node_id = EXCLUDED.node_id, created_at = now()
+		WHERE cluster_rooms.node_id NOT IN (
+			SELECT id FROM cluster_nodes WHERE heartbeat_at > now() - make_interval(secs => $3)
+		)`,
+		room, nodeID, NodeTTL.Seconds())
+	if err != nil {
+		return Node{}, err
+	}
+
+	owner, ok, err := s.RoomOwner(ctx, room)
+	if err != nil {
+		return Node{}, err
+	}
+	if !ok {
+		return Node{}, errOwnerNotAlive
+	}
+
+	return owner, nil
+}
+
+func (s *PostgresStore) RoomOwner(ctx context.Context, room string) (Node, bool, error) {
+	node := Node{}
+	err := s.pool.QueryRow(ctx, `
+		SELECT n.id, n.address, n.heartbeat_at
+		FROM cluster_rooms r JOIN cluster_nodes n ON n.id = r.node_id
+		WHERE r.name = $1 AND n.heartbeat_at > now() - make_interval(secs => $2)`,
+		room, NodeTTL.Seconds()).Scan(&node.ID, &node.Address, &node.HeartbeatAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return Node{}, false, nil
+	}
+	if err != nil {
+		return Node{}, false, err
+	}
+
+	return node, true, nil
+}
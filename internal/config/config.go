@@ -0,0 +1,244 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"webrtc-app/pkg/postgres"
+
+	"github.com/ilyakaznacheev/cleanenv"
+)
+
+// Config — настройки узла SFU. Читаются из YAML-файла, переменные окружения с теми же именами
+// переопределяют значения из файла. Ключи YAML совпадают с именами переменных окружения.
+type Config struct {
+	HTTP     HTTPConfig           `yaml:",inline"`
+	TLS      TLSConfig            `yaml:",inline"`
+	CORS     CORSConfig           `yaml:",inline"`
+	ICE      ICEConfig            `yaml:",inline"`
+	TURN     TURNConfig           `yaml:",inline"`
+	Cluster  ClusterConfig        `yaml:",inline"`
+	Relay    RelayConfig          `yaml:",inline"`
+	Drain    DrainConfig          `yaml:",inline"`
+	Postgres postgres.PostgresCfg `yaml:",inline"`
+	Auth     AuthConfig           `yaml:",inline"`
+	Limits   LimitsConfig         `yaml:",inline"`
+	Log      LogConfig            `yaml:",inline"`
+}
+
+type HTTPConfig struct {
+	Addr           string   `yaml:"HTTP_ADDR" env:"HTTP_ADDR" env-default:":8080"`
+	TrustedProxies []string `yaml:"HTTP_TRUSTED_PROXIES" env:"HTTP_TRUSTED_PROXIES"`                    // Адреса и подсети прокси, которым доверяется X-Forwarded-Proto
+	StaticDir      string   `yaml:"HTTP_STATIC_DIR" env:"HTTP_STATIC_DIR"`                              // Каталог, файлы которого заменяют встроенный веб-клиент
+	AdminAddr      string   `yaml:"HTTP_ADMIN_ADDR" env:"HTTP_ADMIN_ADDR" env-default:"127.0.0.1:9090"` // Служебный листенер /debug/*, пусто — выключен
+}
+
+// TLSConfig — сертификат HTTPS, без него сервер работает по HTTP
+type TLSConfig struct {
+	CertFile       string        `yaml:"TLS_CERT_FILE" env:"TLS_CERT_FILE"`
+	KeyFile        string        `yaml:"TLS_KEY_FILE" env:"TLS_KEY_FILE"`
+	ReloadInterval time.Duration `yaml:"TLS_RELOAD_INTERVAL" env:"TLS_RELOAD_INTERVAL" env-default:"1m"` // Период проверки файлов сертификата
+	RedirectAddr   string        `yaml:"TLS_REDIRECT_ADDR" env:"TLS_REDIRECT_ADDR"`                      // Адрес HTTP-листенера, перенаправляющего на HTTPS
+}
+
+// CORSConfig — сайты, которым разрешено обращаться к API и открывать websocket.
+// Страница самого узла разрешена всегда, "*" — любой сайт без учётных данных.
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"CORS_ALLOWED_ORIGINS" env:"CORS_ALLOWED_ORIGINS"` // Например https://meet.example.com
+}
+
+// ICEConfig — ICE-серверы для клиентов и сетевые настройки PeerConnection сервера
+type ICEConfig struct {
+	STUNServers []string      `yaml:"ICE_STUN_SERVERS" env:"ICE_STUN_SERVERS" env-default:"stun:stun.l.google.com:19302"`
+	TURNServers []string      `yaml:"ICE_TURN_SERVERS" env:"ICE_TURN_SERVERS"`
+	TURNSecret  string        `yaml:"ICE_TURN_SECRET" env:"ICE_TURN_SECRET"`             // Общий секрет TURN REST API
+	TURNTTL     time.Duration `yaml:"ICE_TURN_TTL" env:"ICE_TURN_TTL" env-default:"12h"` // Срок действия выданных учётных данных
+	NAT1To1IPs  []string      `yaml:"ICE_NAT_1TO1_IPS" env:"ICE_NAT_1TO1_IPS"`           // Публичные адреса вместо адресов хоста
+	UDPPortMin  uint16        `yaml:"ICE_UDP_PORT_MIN" env:"ICE_UDP_PORT_MIN"`           // Диапазон UDP-портов, 0 — любые
+	UDPPortMax  uint16        `yaml:"ICE_UDP_PORT_MAX" env:"ICE_UDP_PORT_MAX"`
+	Interfaces  []string      `yaml:"ICE_INTERFACES" env:"ICE_INTERFACES"`     // Интерфейсы для ICE, пусто — все
+	UDPMuxPort  uint16        `yaml:"ICE_UDP_MUX_PORT" env:"ICE_UDP_MUX_PORT"` // Один UDP-порт для всех соединений
+	TCPPort     uint16        `yaml:"ICE_TCP_PORT" env:"ICE_TCP_PORT"`         // Порт пассивных кандидатов ICE-TCP
+}
+
+// TURNConfig — встроенный TURN-сервер, выключен без TURN_LISTEN
+type TURNConfig struct {
+	Listen       string `yaml:"TURN_LISTEN" env:"TURN_LISTEN"`
+	PublicIP     string `yaml:"TURN_PUBLIC_IP" env:"TURN_PUBLIC_IP"`
+	Realm        string `yaml:"TURN_REALM" env:"TURN_REALM" env-default:"webrtc"`
+	RelayPortMin uint16 `yaml:"TURN_RELAY_PORT_MIN" env:"TURN_RELAY_PORT_MIN" env-default:"49152"`
+	RelayPortMax uint16 `yaml:"TURN_RELAY_PORT_MAX" env:"TURN_RELAY_PORT_MAX" env-default:"65535"`
+	UserQuota    int    `yaml:"TURN_USER_QUOTA" env:"TURN_USER_QUOTA" env-default:"10"` // Одновременных подключений одного участника
+}
+
+// ClusterConfig — режим кластера, одиночный узел без CLUSTER_REGISTRY
+type ClusterConfig struct {
+	Registry    string `yaml:"CLUSTER_REGISTRY" env:"CLUSTER_REGISTRY"`         // postgres
+	NodeID      string `yaml:"CLUSTER_NODE_ID" env:"CLUSTER_NODE_ID"`           // По умолчанию имя хоста
+	NodeAddress string `yaml:"CLUSTER_NODE_ADDRESS" env:"CLUSTER_NODE_ADDRESS"` // Базовый URL узла для других узлов и клиентов
+}
+
+type RelayConfig struct {
+	Secret string `yaml:"RELAY_SECRET" env:"RELAY_SECRET"` // Без секрета пересылка между узлами выключена
+}
+
+type DrainConfig struct {
+	Timeout  time.Duration `yaml:"DRAIN_TIMEOUT" env:"DRAIN_TIMEOUT" env-default:"1m"`
+	Redirect string        `yaml:"DRAIN_REDIRECT" env:"DRAIN_REDIRECT"` // Узел, который принимает комнаты при остановке
+}
+
+type AuthConfig struct {
+	TokenURL string `yaml:"AUTH_TOKEN_URL" env:"AUTH_TOKEN_URL"` // Сервер проверки токенов для verifytoken.ValidateToken, узел сам токены не проверяет
+}
+
+type LimitsConfig struct {
+	MaxScreenShares   int `yaml:"LIMIT_MAX_SCREEN_SHARES" env:"LIMIT_MAX_SCREEN_SHARES" env-default:"1"` // По умолчанию для новых комнат
+	ChatHistory       int `yaml:"LIMIT_CHAT_HISTORY" env:"LIMIT_CHAT_HISTORY" env-default:"100"`         // Сообщений в истории комнаты
+	OutboundQueueSize int `yaml:"LIMIT_OUTBOUND_QUEUE" env:"LIMIT_OUTBOUND_QUEUE" env-default:"256"`     // Сообщений в очереди отправки websocket
+}
+
+type LogConfig struct {
+	Level  string `yaml:"LOG_LEVEL" env:"LOG_LEVEL" env-default:"info"`
+	Format string `yaml:"LOG_FORMAT" env:"LOG_FORMAT" env-default:"text"` // text или json
+}
+
+var (
+	errTLSFiles         = errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	errTLSRedirect      = errors.New("TLS_REDIRECT_ADDR requires TLS_CERT_FILE and TLS_KEY_FILE")
+	errTLSReload        = errors.New("TLS_RELOAD_INTERVAL must be positive")
+	errICEPortsConflict = errors.New("ICE_UDP_MUX_PORT cannot be combined with ICE_UDP_PORT_MIN/ICE_UDP_PORT_MAX")
+	errTURNPublicIP     = errors.New("TURN_PUBLIC_IP is required for the embedded TURN server")
+	errNodeAddress      = errors.New("CLUSTER_NODE_ADDRESS is required in cluster mode")
+	errMemoryRegistry   = errors.New("CLUSTER_REGISTRY=memory is test-only: each process would have its own registry")
+	errLimits           = errors.New("LIMIT_* values must be positive")
+)
+
+// Load читает конфигурацию из файла path и переменных окружения, без файла — только из окружения
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	var err error
+	if path != "" {
+		err = cleanenv.ReadConfig(path, cfg)
+	} else {
+		err = cleanenv.ReadEnv(cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate проверяет согласованность настроек
+func (c *Config) Validate() error {
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errTLSFiles
+	}
+	if c.TLS.RedirectAddr != "" && c.TLS.CertFile == "" {
+		return errTLSRedirect
+	}
+	if c.TLS.CertFile != "" && c.TLS.ReloadInterval <= 0 {
+		return errTLSReload
+	}
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		if !validOrigin(origin) {
+			return fmt.Errorf("invalid CORS_ALLOWED_ORIGINS entry %q, expected scheme://host[:port] or *", origin)
+		}
+	}
+
+	for _, proxy := range c.HTTP.TrustedProxies {
+		if _, err := ParsePrefix(proxy); err != nil {
+			return fmt.Errorf("invalid HTTP_TRUSTED_PROXIES entry %q", proxy)
+		}
+	}
+
+	if c.ICE.UDPPortMin != 0 || c.ICE.UDPPortMax != 0 {
+		if c.ICE.UDPPortMin == 0 || c.ICE.UDPPortMin > c.ICE.UDPPortMax {
+			return fmt.Errorf("invalid ICE UDP port range %d-%d", c.ICE.UDPPortMin, c.ICE.UDPPortMax)
+		}
+		if c.ICE.UDPMuxPort != 0 {
+			return errICEPortsConflict
+		}
+	}
+
+	if c.TURN.Listen != "" {
+		if net.ParseIP(c.TURN.PublicIP) == nil {
+			return errTURNPublicIP
+		}
+		if c.TURN.RelayPortMin == 0 || c.TURN.RelayPortMin > c.TURN.RelayPortMax {
+			return fmt.Errorf("invalid TURN relay port range %d-%d", c.TURN.RelayPortMin, c.TURN.RelayPortMax)
+		}
+	}
+	if c.TURN.UserQuota <= 0 {
+		return fmt.Errorf("invalid TURN_USER_QUOTA %d", c.TURN.UserQuota)
+	}
+
+	switch c.Cluster.Registry {
+	case "":
+	case "postgres":
+		if c.Cluster.NodeAddress == "" {
+			return errNodeAddress
+		}
+	case "memory":
+		return errMemoryRegistry
+	default:
+		return fmt.Errorf("unknown cluster registry %q", c.Cluster.Registry)
+	}
+
+	if c.Limits.MaxScreenShares < 0 || c.Limits.ChatHistory <= 0 || c.Limits.OutboundQueueSize <= 0 {
+		return errLimits
+	}
+
+	switch c.Log.Level {
+	case "trace", "debug", "info", "warn", "error", "disabled":
+	default:
+		return fmt.Errorf("unknown LOG_LEVEL %q", c.Log.Level)
+	}
+
+	switch c.Log.Format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("unknown LOG_FORMAT %q", c.Log.Format)
+	}
+
+	return nil
+}
+
+// validOrigin проверяет источник вида scheme://host[:port] без пути
+func validOrigin(origin string) bool {
+	if origin == "*" {
+		return true
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(origin, "/"))
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" && u.Path == "" && u.RawQuery == "" && u.User == nil
+}
+
+// ParsePrefix разбирает подсеть CIDR или одиночный адрес
+func ParsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		return netip.ParsePrefix(value)
+	}
+
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
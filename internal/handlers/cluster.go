@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// forwardToRoomOwner отправляет запрос узлу-владельцу комнаты, если комната живёт не здесь.
+// В кластере комната живёт на узле, который её создал; запросы к ней на других узлах уходят владельцу.
+// С proxy запрос проксируется: браузер не следует редиректам при Upgrade, а после редиректа на другой узел
+// его Origin уже не совпадает с адресом узла. Остальные запросы перенаправляются (307).
+// Возвращает true, если запрос уже обработан.
+func (s *Server) forwardToRoomOwner(w http.ResponseWriter, r *http.Request, roomName string, proxy bool) bool {
+	if s.cluster == nil || roomName == "" {
+		return false
+	}
+
+	s.roomsLock.RLock()
+	_, local := s.rooms[roomName]
+	s.roomsLock.RUnlock()
+
+	if local {
+		return false
+	}
+
+	owner, ok, err := s.cluster.RoomOwner(r.Context(), roomName)
+	if err != nil {
+		s.requestLog(r).Error("Failed to look up room owner", "room", roomName, "err", err)
+		http.Error(w, "Cluster unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+
+	// Комнаты нет ни у кого, обработчик ответит сам
+	if !ok || owner.ID == s.cluster.Self.ID {
+		return false
+	}
+
+	target, err := url.Parse(owner.Address)
+	if err != nil {
+		s.requestLog(r).Error("Invalid node address", "node", owner.ID, "err", err)
+		http.Error(w, "Cluster unavailable", http.StatusServiceUnavailable)
+		return true
+	}
+
+	if proxy {
+		s.requestLog(r).Info("Proxying room to owner", "room", roomName, "node", owner.ID)
+		proxy := httputil.NewSingleHostReverseProxy(target)
+		// CORS-заголовки уже выставил этот узел, повтор от владельца браузер не примет
+		proxy.ModifyResponse = func(response *http.Response) error {
+			for name := range response.Header {
+				if strings.HasPrefix(name, "Access-Control-") {
+					response.Header.Del(name)
+				}
+			}
+			return nil
+		}
+		proxy.ServeHTTP(w, r)
+		return true
+	}
+
+	location := *r.URL
+	location.Scheme = target.Scheme
+	location.Host = target.Host
+	http.Redirect(w, r, location.String(), http.StatusTemporaryRedirect)
+
+	return true
+}
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"webrtc-app/internal/cluster"
+)
+
+// newTestClusterNode запускает узел кластера на httptest-листенере с общим реестром store
+func newTestClusterNode(t *testing.T, store cluster.Store, id string) (*Server, *httptest.Server) {
+	t.Helper()
+
+	node := cluster.New(store, cluster.Node{ID: id})
+	s, err := NewServer(testConfig(t), node, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	ts := serveTestServer(t, s)
+	node.Self.Address = ts.URL
+	if err := node.Join(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, ts
+}
+
+// HTTP-запросы к чужой комнате перенаправляются владельцу
+func TestForwardToRoomOwnerRedirect(t *testing.T) {
+	store := cluster.NewMemoryStore()
+	owner, ownerTS := newTestClusterNode(t, store, "a")
+	_, otherTS := newTestClusterNode(t, store, "b")
+	createTestRoom(t, owner, ownerTS.URL, "clustered")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	request, err := http.NewRequest(http.MethodPost, otherTS.URL+"/whip/clustered", strings.NewReader("v=0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer pw")
+	request.Header.Set("Content-Type", sdpContentType)
+
+	response, err := client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("status %s, expected a redirect to the owner", response.Status)
+	}
+	if location := response.Header.Get("Location"); location != ownerTS.URL+"/whip/clustered" {
+		t.Fatalf("redirected to %q", location)
+	}
+
+	// Комнату, которой нет ни у кого, узел обрабатывает сам
+	request, err = http.NewRequest(http.MethodPost, otherTS.URL+"/whip/missing", strings.NewReader("v=0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer pw")
+
+	response, err = client.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusNotFound {
+		t.Fatalf("status %s for a room without owner", response.Status)
+	}
+}
+
+// Websocket к чужой комнате проксируется владельцу: браузер не следует редиректам при Upgrade
+func TestForwardToRoomOwnerProxy(t *testing.T) {
+	store := cluster.NewMemoryStore()
+	owner, ownerTS := newTestClusterNode(t, store, "a")
+	other, otherTS := newTestClusterNode(t, store, "b")
+	room := createTestRoom(t, owner, ownerTS.URL, "clustered")
+
+	client := dialTestClient(t, otherTS.URL, "clustered")
+	client.answer(client.offer())
+
+	waitFor(t, "participant on the owner node", func() bool {
+		room.ListLock.RLock()
+		defer room.ListLock.RUnlock()
+
+		return len(room.Peers) == 1
+	})
+
+	if other.testRoom("clustered") != nil {
+		t.Fatal("proxying node created its own copy of the room")
+	}
+}
+
+// Проверка комнаты со страницы другого узла проксируется владельцу: после редиректа Origin страницы был бы чужим
+func TestCheckRoomProxiedToOwner(t *testing.T) {
+	store := cluster.NewMemoryStore()
+	owner, ownerTS := newTestClusterNode(t, store, "a")
+	_, otherTS := newTestClusterNode(t, store, "b")
+	createTestRoom(t, owner, ownerTS.URL, "clustered")
+
+	request, err := http.NewRequest(http.MethodPost, otherTS.URL+"/api/check-room", strings.NewReader(`{"name":"clustered","password":"pw"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Origin", otherTS.URL)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("check room from another node's page: %s", response.Status)
+	}
+	if origins := response.Header.Values("Access-Control-Allow-Origin"); len(origins) != 1 || origins[0] != otherTS.URL {
+		t.Fatalf("Access-Control-Allow-Origin %q", origins)
+	}
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"webrtc-app/internal/config"
+
+	"github.com/gorilla/websocket"
+)
+
+// Переполнение очереди медленного клиента закрывает соединение, не блокируя пишущих
+func TestThreadSafeWriterOverflow(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Limits.OutboundQueueSize = 4
+	})
+
+	conns := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	defer ts.Close()
+
+	// Клиент ничего не читает, поэтому буферы TCP заполняются и очередь переполняется
+	client, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http", "ws", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	writer := s.newThreadSafeWriter(<-conns, s.log)
+	payload := chatPayload{Text: strings.Repeat("x", 64<<10)}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		overflow int
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				err := writer.WriteEvent("chat", payload)
+				if errors.Is(err, errSlowConsumer) {
+					mu.Lock()
+					overflow++
+					mu.Unlock()
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(testTimeout):
+		t.Fatal("writers blocked on a slow client")
+	}
+
+	if overflow == 0 {
+		t.Fatal("outbound queue never overflowed")
+	}
+	if err := writer.WriteEvent("chat", payload); !errors.Is(err, errWriterClosed) {
+		t.Fatalf("write after overflow returned %v, expected errWriterClosed", err)
+	}
+}
+
+// Рассылка событий, согласование и запросы ключевых кадров в одной комнате не гоняются друг с другом
+func TestRoomConcurrentSignaling(t *testing.T) {
+	s := newTestServer(t)
+	ts := serveTestServer(t, s)
+	room := createTestRoom(t, s, ts.URL, "concurrency")
+
+	clients := make([]*testClient, 3)
+	for i := range clients {
+		clients[i] = dialTestClient(t, ts.URL, "concurrency")
+	}
+	waitFor(t, "participants", func() bool {
+		room.ListLock.RLock()
+		defer room.ListLock.RUnlock()
+
+		return len(room.Peers) == len(clients)
+	})
+
+	// Клиенты отвечают на все offer, пока комната меняется
+	var answering sync.WaitGroup
+	stop := make(chan struct{})
+	for _, client := range clients {
+		answering.Add(1)
+		go func(c *testClient) {
+			defer answering.Done()
+			for {
+				select {
+				case message, ok := <-c.messages:
+					if !ok {
+						return
+					}
+					if message.Type == "offer" {
+						var offer offerPayload
+						if err := json.Unmarshal(message.Payload, &offer); err != nil {
+							t.Error(err)
+							return
+						}
+						if err := c.respond(offer); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(client)
+	}
+
+	var wg sync.WaitGroup
+	run := func(iterations int, action func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				action(i)
+			}
+		}()
+	}
+
+	run(50, func(i int) {
+		room.broadcastEvent("chat", chatPayload{Sender: "test", Text: fmt.Sprint(i)}, nil)
+	})
+	run(20, func(int) { room.signalPeerConnections() })
+	run(50, func(int) { room.DispatchKeyFrame() })
+	run(10, func(i int) {
+		id := fmt.Sprintf("track-%d", i)
+		addTestTrack(t, room, id)
+
+		room.ListLock.Lock()
+		delete(room.TrackLocals, id)
+		delete(room.Tracks, id)
+		room.ListLock.Unlock()
+		room.signalPeerConnections()
+	})
+
+	wg.Wait()
+	close(stop)
+	answering.Wait()
+
+	room.ListLock.RLock()
+	defer room.ListLock.RUnlock()
+	if len(room.Peers) != len(clients) {
+		t.Fatalf("%d participants left the room, expected %d", len(room.Peers), len(clients))
+	}
+}
@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Политика источников для API и websocket. Запросы без Origin (серверные клиенты, WHIP-кодировщики)
+// и со страницы самого узла разрешены всегда, чужие сайты — только из CORS_ALLOWED_ORIGINS.
+
+// Время кеширования ответа на preflight браузером, в секундах
+const corsMaxAge = "600"
+
+// originPolicy — разобранный CORS_ALLOWED_ORIGINS
+type originPolicy struct {
+	any     bool            // "*": любой источник, но без учётных данных
+	origins map[string]bool // Источники в виде scheme://host[:port]
+}
+
+func newOriginPolicy(allowed []string) originPolicy {
+	policy := originPolicy{origins: make(map[string]bool, len(allowed))}
+	for _, origin := range allowed {
+		if origin == "*" {
+			policy.any = true
+			continue
+		}
+		policy.origins[normalizeOrigin(origin)] = true
+	}
+
+	return policy
+}
+
+// normalizeOrigin приводит источник к виду из заголовка Origin
+func normalizeOrigin(origin string) string {
+	return strings.ToLower(strings.TrimSuffix(origin, "/"))
+}
+
+// listed сообщает, что источник указан в списке явно
+func (p originPolicy) listed(origin string) bool {
+	return p.origins[normalizeOrigin(origin)]
+}
+
+// sameOrigin сообщает, что запрос пришёл со страницы, которую отдал этот же узел
+func sameOrigin(r *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// originAllowed проверяет заголовок Origin запроса, используется и для websocket (CheckOrigin)
+func (s *Server) originAllowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameOrigin(r, origin) {
+		return true
+	}
+
+	return s.origins.any || s.origins.listed(origin)
+}
+
+// EnableCORS добавляет CORS-заголовки для разрешённых источников и отклоняет запросы с остальных.
+// Явно перечисленным источникам разрешены учётные данные (cookies, Authorization);
+// при "*" ответ отдаётся любому сайту, но без них.
+func (s *Server) EnableCORS(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Ответ зависит от Origin, кеши не должны отдавать его другому источнику
+		w.Header().Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if !s.originAllowed(r) {
+			s.requestLog(r).Warn("Rejected request from origin", "origin", origin, "path", r.URL.Path)
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		if origin != "" {
+			if s.origins.listed(origin) || sameOrigin(r, origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			}
+			w.Header().Set("Access-Control-Expose-Headers", "Location, Link")
+		}
+
+		// Предварительный запрос (preflight) для CORS
+		if r.Method == "OPTIONS" {
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		next(w, r)
+	}
+}
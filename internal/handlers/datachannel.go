@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Метки каналов данных, которые сервер открывает каждому участнику.
+// По reliable идут упорядоченные сообщения с гарантией доставки (доска),
+// по unreliable — неупорядоченные без повторов (курсоры и прочие частые обновления).
+const (
+	dataChannelReliable   = "reliable"
+	dataChannelUnreliable = "unreliable"
+
+	// Порог буфера SCTP, после которого частые обновления медленному участнику не отправляются
+	maxUnreliableBufferedAmount = 1 << 20
+
+	// Порог буфера SCTP для каналов с гарантией доставки, после которого канал медленного участника закрывается
+	maxReliableBufferedAmount = 8 << 20
+)
+
+// dataChannelMessage — конверт сообщения приложения, пересылаемого через DataChannel.
+// Если To пустой, сообщение рассылается всем остальным участникам комнаты.
+type dataChannelMessage struct {
+	From    string          `json:"from,omitempty"`
+	Sender  string          `json:"sender,omitempty"`
+	To      string          `json:"to,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// dataChannelSet хранит каналы данных участника по их меткам
+type dataChannelSet struct {
+	sync.RWMutex
+	byLabel map[string]*webrtc.DataChannel
+}
+
+func (s *dataChannelSet) set(d *webrtc.DataChannel) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.byLabel == nil {
+		s.byLabel = make(map[string]*webrtc.DataChannel)
+	}
+	s.byLabel[d.Label()] = d
+}
+
+func (s *dataChannelSet) remove(d *webrtc.DataChannel) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.byLabel[d.Label()] == d {
+		delete(s.byLabel, d.Label())
+	}
+}
+
+func (s *dataChannelSet) get(label string) *webrtc.DataChannel {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.byLabel[label]
+}
+
+// openDataChannels создаёт на стороне сервера стандартные каналы данных участника.
+// Клиент получает их через ondatachannel вместе с первым offer.
+func (r *Room) openDataChannels(pcState *peerConnectionState) error {
+	reliable, err := pcState.peerConnection.CreateDataChannel(dataChannelReliable, nil)
+	if err != nil {
+		return err
+	}
+	r.attachDataChannel(pcState, reliable)
+
+	ordered := false
+	maxRetransmits := uint16(0)
+	unreliable, err := pcState.peerConnection.CreateDataChannel(dataChannelUnreliable, &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return err
+	}
+	r.attachDataChannel(pcState, unreliable)
+
+	return nil
+}
+
+// attachDataChannel регистрирует канал участника и пересылает его сообщения в комнату
+func (r *Room) attachDataChannel(pcState *peerConnectionState, d *webrtc.DataChannel) {
+	pcState.dataChannels.set(d)
+
+	d.OnClose(func() {
+		pcState.dataChannels.remove(d)
+	})
+
+	d.OnMessage(func(msg webrtc.DataChannelMessage) {
+		r.relayDataChannelMessage(pcState, d.Label(), msg)
+	})
+}
+
+// relayDataChannelMessage пересылает сообщение в одноимённые каналы других участников.
+// Текстовые сообщения должны быть конвертом dataChannelMessage, бинарные рассылаются всем как есть.
+func (r *Room) relayDataChannelMessage(from *peerConnectionState, label string, msg webrtc.DataChannelMessage) {
+	data := msg.Data
+	to := ""
+
+	if msg.IsString {
+		var envelope dataChannelMessage
+		if err := json.Unmarshal(msg.Data, &envelope); err != nil {
+			from.log.Error("Failed to unmarshal data channel message", "err", err)
+			return
+		}
+
+		envelope.From = from.id
+		envelope.Sender = from.username
+		to = envelope.To
+
+		var err error
+		if data, err = json.Marshal(envelope); err != nil {
+			from.log.Error("Failed to marshal data channel message", "err", err)
+			return
+		}
+	}
+
+	r.ListLock.RLock()
+	defer r.ListLock.RUnlock()
+
+	for _, peer := range r.Peers {
+		if peer == from || (to != "" && peer.id != to) {
+			continue
+		}
+
+		d := peer.dataChannels.get(label)
+		if d == nil || d.ReadyState() != webrtc.DataChannelStateOpen {
+			continue
+		}
+
+		if label == dataChannelUnreliable && d.BufferedAmount() > maxUnreliableBufferedAmount {
+			continue
+		}
+
+		// Пропуск сообщения нарушил бы порядок и гарантию доставки, поэтому такой канал закрывается
+		if label != dataChannelUnreliable && d.BufferedAmount() > maxReliableBufferedAmount {
+			peer.log.Warn("Closing slow data channel", "channel", label, "buffered", d.BufferedAmount())
+			d.Close()
+			continue
+		}
+
+		var err error
+		if msg.IsString {
+			err = d.SendText(string(data))
+		} else {
+			err = d.Send(data)
+		}
+		if err != nil {
+			peer.log.Error("Failed to relay data channel message", "err", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Плавная остановка узла: новые входы отклоняются, участники получают событие server_draining
+// и покидают комнаты сами, по истечении DRAIN_TIMEOUT оставшиеся соединения закрываются.
+
+// Период проверки, опустели ли комнаты
+const drainPollInterval = time.Second
+
+var errDraining = errors.New("server is draining")
+
+// drainingInfo — событие server_draining
+type drainingInfo struct {
+	Redirect string    `json:"redirect,omitempty"` // Узел, на котором продолжается встреча
+	Deadline time.Time `json:"deadline"`           // Время принудительного закрытия соединений
+}
+
+// Draining сообщает, останавливается ли узел
+func (s *Server) Draining() bool {
+	return s.draining.Load()
+}
+
+// rejectDraining отклоняет новые входы на останавливающийся узел. Возвращает true, если запрос отклонён.
+func (s *Server) rejectDraining(w http.ResponseWriter) bool {
+	if !s.draining.Load() {
+		return false
+	}
+
+	w.Header().Set("Retry-After", "5")
+	http.Error(w, "Server is draining", http.StatusServiceUnavailable)
+
+	return true
+}
+
+// Drain останавливает приём участников, переносит комнаты на DRAIN_REDIRECT и ждёт, пока комнаты опустеют.
+// После ctx или DRAIN_TIMEOUT закрывает все PeerConnection.
+func (s *Server) Drain(ctx context.Context) {
+	s.draining.Store(true)
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Drain.Timeout)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	rooms := s.roomList()
+
+	s.log.Info("Draining", "rooms", len(rooms), "deadline", deadline.Format(time.RFC3339))
+
+	// Узел выходит из кластера, чтобы комнаты мог занять другой узел
+	if s.cluster != nil {
+		if err := s.cluster.Leave(ctx); err != nil {
+			s.log.Error("Failed to leave cluster", "err", err)
+		}
+	}
+
+	redirect := strings.TrimSuffix(s.cfg.Drain.Redirect, "/")
+	for _, room := range rooms {
+		if redirect != "" {
+			if err := s.migrateRoom(ctx, room, redirect); err != nil {
+				room.log.Error("Failed to migrate room", "target", redirect, "err", err)
+			}
+		}
+
+		room.broadcastEvent("server_draining", drainingInfo{Redirect: redirect, Deadline: deadline}, nil)
+	}
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for !roomsEmpty(rooms) {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			s.log.Warn("Drain deadline exceeded, closing remaining connections")
+			s.closeRooms(rooms)
+			return
+		}
+	}
+
+	s.closeRooms(rooms)
+	s.log.Info("All rooms drained")
+}
+
+// migrateRoom создаёт комнату с тем же именем и паролем на узле, который принимает встречу
+func (s *Server) migrateRoom(ctx context.Context, room *Room, target string) error {
+	s.roomsLock.RLock()
+	password := s.passwords[room.Name]
+	s.roomsLock.RUnlock()
+
+	body, err := json.Marshal(CreateRoomRequest{Name: room.Name, Password: password, MaxScreenShares: room.MaxScreenShares})
+	if err != nil {
+		return err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, target+"/api/create-room", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	// Комната уже может существовать на целевом узле
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusConflict {
+		return fmt.Errorf("create room rejected: %s", response.Status)
+	}
+
+	return nil
+}
+
+// roomList возвращает все комнаты узла
+func (s *Server) roomList() []*Room {
+	s.roomsLock.RLock()
+	defer s.roomsLock.RUnlock()
+
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+
+	return rooms
+}
+
+// roomsEmpty сообщает, что в комнатах не осталось участников, публикаций WHIP и зрителей WHEP
+func roomsEmpty(rooms []*Room) bool {
+	for _, room := range rooms {
+		room.ListLock.RLock()
+		empty := len(room.Peers) == 0 && len(room.Publishers) == 0 && len(room.Viewers) == 0
+		room.ListLock.RUnlock()
+
+		if !empty {
+			return false
+		}
+	}
+
+	return true
+}
+
+// closeRooms закрывает все PeerConnection комнат, включая пересылку между узлами.
+// Websocket участника закрывается вместе с его PeerConnection.
+func (s *Server) closeRooms(rooms []*Room) {
+	for _, room := range rooms {
+		room.ListLock.RLock()
+		connections := make([]*webrtc.PeerConnection, 0, len(room.Peers))
+		for _, peer := range room.Peers {
+			connections = append(connections, peer.peerConnection)
+		}
+		for _, sessions := range []map[string]*peerConnectionState{room.Publishers, room.Viewers, room.RelaySinks, room.RelaySources} {
+			for _, session := range sessions {
+				connections = append(connections, session.peerConnection)
+			}
+		}
+		room.ListLock.RUnlock()
+
+		for _, peerConnection := range connections {
+			if err := peerConnection.Close(); err != nil {
+				room.log.Error("Failed to close PeerConnection", "err", err)
+			}
+		}
+	}
+}
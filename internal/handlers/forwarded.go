@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"webrtc-app/internal/config"
+)
+
+// parseTrustedProxies разбирает HTTP_TRUSTED_PROXIES
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		prefix, err := config.ParsePrefix(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// fromTrustedProxy сообщает, что запрос пришёл с адреса доверенного прокси
+func (s *Server) fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return false
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSecure сообщает, что клиент обратился по HTTPS: напрямую по TLS
+// или через доверенный прокси с X-Forwarded-Proto: https. Заголовку от остальных адресов не верим.
+func (s *Server) isSecure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	if !s.fromTrustedProxy(r) {
+		return false
+	}
+
+	// Цепочка прокси добавляет значения через запятую, первое — протокол клиента
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// baseURL возвращает адрес узла, по которому к нему обратился клиент
+func (s *Server) baseURL(r *http.Request) string {
+	if s.isSecure(r) {
+		return "https://" + r.Host
+	}
+
+	return "http://" + r.Host
+}
+
+// WebsocketURL возвращает адрес signaling-websocket для страницы: wss:// для клиентов по HTTPS
+func (s *Server) WebsocketURL(r *http.Request) string {
+	if s.isSecure(r) {
+		return "wss://" + r.Host + "/websocket"
+	}
+
+	return "ws://" + r.Host + "/websocket"
+}
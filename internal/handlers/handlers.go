@@ -1,57 +1,43 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"webrtc-app/internal/logger"
+
 	// verifytoken "webrtc-app/test-verify-token"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"github.com/pion/logging"
 	"github.com/pion/rtcp"
 	"github.com/pion/rtp"
 	"github.com/pion/webrtc/v4"
 )
 
-// nolint
-var (
-	Addr     = flag.String("addr", ":8080", "http service address")
-	upgrader = websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool { return true },
-	}
-
-	Rooms         = make(map[string]*Room)
-	roomPasswords = make(map[string]string) // Хранилище паролей комнат
-	RoomsLock     sync.RWMutex
+// Предельное время записи одного сообщения в websocket
+const writeWait = 10 * time.Second
 
-	log = logging.NewDefaultLoggerFactory().NewLogger("sfu-ws")
+var (
+	errWriterClosed = errors.New("websocket writer closed")
+	errSlowConsumer = errors.New("websocket outbound queue overflow")
 )
 
-// enableCORS добавляет CORS заголовки к ответу
-func EnableCORS(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization")
-
-		// Предварительный запрос (preflight) для CORS
-		if r.Method == "OPTIONS" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next(w, r)
-	}
-}
-
 // Структуры запросов для API
 type CreateRoomRequest struct {
-	Name     string `json:"name"`
-	Password string `json:"password"`
+	Name            string `json:"name"`
+	Password        string `json:"password"`
+	MaxScreenShares int    `json:"max_screen_shares,omitempty"`
 }
 
 type JoinRoomRequest struct {
@@ -62,6 +48,7 @@ type JoinRoomRequest struct {
 
 // ChatMessage представляет сообщение в чате
 type ChatMessage struct {
+	ID        uint64    `json:"id"` // Порядковый номер в комнате, по нему клиент получает пропущенное после переподключения
 	Sender    string    `json:"sender"`
 	Text      string    `json:"text"`
 	Timestamp time.Time `json:"timestamp"`
@@ -69,18 +56,51 @@ type ChatMessage struct {
 
 type Room struct {
 	Name        string
-	Peers       []peerConnectionState
+	Peers       []*peerConnectionState
 	TrackLocals map[string]*webrtc.TrackLocalStaticRTP
+	Tracks      map[string]*trackInfo           // Реестр треков: владелец, источник, mute
+	Publishers  map[string]*peerConnectionState // Сессии WHIP, публикующие без websocket
+	Viewers     map[string]*peerConnectionState // Сессии WHEP, только смотрят и не входят в состав комнаты
+
+	RelaySinks   map[string]*peerConnectionState // Пересылка треков комнаты на другие узлы
+	RelaySources map[string]*peerConnectionState // Пересылка треков с других узлов в комнату
+
 	ChatHistory []ChatMessage
 	ListLock    sync.RWMutex
+
+	chatSeq uint64 // Номер последнего сообщения чата
+
+	MaxScreenShares int // Лимит одновременных демонстраций экрана
+
+	server *Server
+	log    *slog.Logger // Логгер с полем room
+}
+
+// newRoom создаёт комнату узла. Регистрирует её вызывающий под roomsLock.
+func (s *Server) newRoom(name string, maxScreenShares int) *Room {
+	return &Room{
+		Name:            name,
+		TrackLocals:     make(map[string]*webrtc.TrackLocalStaticRTP),
+		Tracks:          make(map[string]*trackInfo),
+		Publishers:      make(map[string]*peerConnectionState),
+		Viewers:         make(map[string]*peerConnectionState),
+		RelaySinks:      make(map[string]*peerConnectionState),
+		RelaySources:    make(map[string]*peerConnectionState),
+		ChatHistory:     make([]ChatMessage, 0),
+		MaxScreenShares: maxScreenShares,
+		server:          s,
+		log:             s.log.With("room", name),
+	}
 }
 
 // Добавляем метод для добавления сообщения в историю чата
-func (r *Room) addChatMessage(sender, text string) {
+func (r *Room) addChatMessage(sender, text string) ChatMessage {
 	r.ListLock.Lock()
 	defer r.ListLock.Unlock()
 
+	r.chatSeq++
 	message := ChatMessage{
+		ID:        r.chatSeq,
 		Sender:    sender,
 		Text:      text,
 		Timestamp: time.Now(),
@@ -88,10 +108,12 @@ func (r *Room) addChatMessage(sender, text string) {
 
 	r.ChatHistory = append(r.ChatHistory, message)
 
-	// Ограничиваем размер истории (например, последние 100 сообщений)
-	if len(r.ChatHistory) > 100 {
-		r.ChatHistory = r.ChatHistory[len(r.ChatHistory)-100:]
+	// Ограничиваем размер истории последними LIMIT_CHAT_HISTORY сообщениями
+	if limit := r.server.cfg.Limits.ChatHistory; len(r.ChatHistory) > limit {
+		r.ChatHistory = r.ChatHistory[len(r.ChatHistory)-limit:]
 	}
+
+	return message
 }
 
 // Добавляем метод для отправки истории чата новому участнику
@@ -103,25 +125,10 @@ func (r *Room) sendChatHistory(ws *threadSafeWriter) error {
 		return nil
 	}
 
-	historyMessage := websocketMessage{
-		Event:  "chat_history",
-		Sender: "system",
-		Data:   "", // Данные будут в отдельном поле
-	}
-
-	// Преобразуем историю в JSON
-	historyJSON, err := json.Marshal(r.ChatHistory)
-	if err != nil {
-		return err
-	}
-
-	// Используем поле Data для истории
-	historyMessage.Data = string(historyJSON)
-
-	return ws.WriteJSON(&historyMessage)
+	return ws.WriteEvent("chat_history", r.ChatHistory)
 }
 
-func (r *Room) addTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
+func (r *Room) addTrack(t *webrtc.TrackRemote, owner *peerConnectionState, source string) (*webrtc.TrackLocalStaticRTP, *trackInfo) {
 	r.ListLock.Lock()
 	defer func() {
 		r.ListLock.Unlock()
@@ -133,7 +140,20 @@ func (r *Room) addTrack(t *webrtc.TrackRemote) *webrtc.TrackLocalStaticRTP {
 		panic(err)
 	}
 	r.TrackLocals[t.ID()] = trackLocal
-	return trackLocal
+	track := &trackInfo{
+		TrackID:       t.ID(),
+		StreamID:      t.StreamID(),
+		ParticipantID: owner.id,
+		Username:      owner.username,
+		Kind:          t.Kind().String(),
+		Source:        source,
+		paused:        &atomic.Bool{},
+	}
+	if owner.relay != nil {
+		owner.relay.describe(track)
+	}
+	r.Tracks[t.ID()] = track
+	return trackLocal, track
 }
 
 func (r *Room) removeTrack(t *webrtc.TrackLocalStaticRTP) {
@@ -143,127 +163,254 @@ func (r *Room) removeTrack(t *webrtc.TrackLocalStaticRTP) {
 		r.signalPeerConnections()
 	}()
 	delete(r.TrackLocals, t.ID())
+	delete(r.Tracks, t.ID())
+}
+
+// forwardTrack публикует входящий трек участника в комнате и пересылает его RTP всем подписчикам,
+// пока трек не закончится
+func (r *Room) forwardTrack(pcState *peerConnectionState, t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+	source := r.trackSource(pcState, t, receiver)
+	pcState.log.Info("Got remote track", "kind", t.Kind().String(), "track", t.ID(), "payload_type", t.PayloadType(), "source", source)
+
+	// Create a track to fan out our incoming video to all peers
+	trackLocal, track := r.addTrack(t, pcState, source)
+	defer func() {
+		r.removeTrack(trackLocal)
+		r.notifyParticipantUpdated(pcState)
+	}()
+
+	r.notifyParticipantUpdated(pcState)
+
+	buf := make([]byte, 1500)
+	rtpPkt := &rtp.Packet{}
+
+	for {
+		i, _, err := t.Read(buf)
+		if err != nil {
+			return
+		}
+
+		if err = rtpPkt.Unmarshal(buf[:i]); err != nil {
+			pcState.log.Error("Failed to unmarshal incoming RTP packet", "err", err)
+			return
+		}
+
+		// Заглушенный трек не пересылаем подписчикам
+		if track.paused.Load() {
+			continue
+		}
+
+		rtpPkt.Extension = false
+		rtpPkt.Extensions = nil
+
+		if err = trackLocal.WriteRTP(rtpPkt); err != nil {
+			return
+		}
+	}
 }
 
 func (r *Room) signalPeerConnections() {
+	var left []participantInfo
+
 	r.ListLock.Lock()
 	defer func() {
 		r.ListLock.Unlock()
+		for _, participant := range left {
+			r.broadcastEvent("participant_left", participant, nil)
+		}
 		r.DispatchKeyFrame()
 	}()
 
-	attemptSync := func() bool {
-		for i := 0; i < len(r.Peers); {
-			pcState := &r.Peers[i]
-			if pcState.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
-				r.Peers = append(r.Peers[:i], r.Peers[i+1:]...)
-				continue
-			}
+	tracks := r.tracksSnapshot()
 
-			existingSenders := map[string]bool{}
+	for i := 0; i < len(r.Peers); {
+		pcState := r.Peers[i]
+		if pcState.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			left = append(left, r.participantInfo(pcState))
+			r.Peers = append(r.Peers[:i], r.Peers[i+1:]...)
+			continue
+		}
 
-			for _, sender := range pcState.peerConnection.GetSenders() {
-				if sender.Track() == nil {
-					continue
-				}
-				existingSenders[sender.Track().ID()] = true
-				if _, ok := r.TrackLocals[sender.Track().ID()]; !ok {
-					if err := pcState.peerConnection.RemoveTrack(sender); err != nil {
-						return true
-					}
-				}
-			}
+		r.syncPeerConnection(pcState, tracks)
+		i++
+	}
 
-			for _, receiver := range pcState.peerConnection.GetReceivers() {
-				if receiver.Track() == nil {
-					continue
-				}
-				existingSenders[receiver.Track().ID()] = true
-			}
+	for id, viewer := range r.Viewers {
+		if viewer.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			delete(r.Viewers, id)
+			continue
+		}
 
-			for trackID := range r.TrackLocals {
-				if _, ok := existingSenders[trackID]; !ok {
-					if _, err := pcState.peerConnection.AddTrack(r.TrackLocals[trackID]); err != nil {
-						return true
-					}
-				}
-			}
+		r.syncPeerConnection(viewer, tracks)
+	}
 
-			offer, err := pcState.peerConnection.CreateOffer(nil)
-			if err != nil {
-				return true
-			}
-			if err = pcState.peerConnection.SetLocalDescription(offer); err != nil {
-				return true
-			}
+	for id, sink := range r.RelaySinks {
+		if sink.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+			delete(r.RelaySinks, id)
+			continue
+		}
 
-			offerString, err := json.Marshal(offer)
-			if err != nil {
-				log.Errorf("Failed to marshal offer to json: %v", err)
-				return true
-			}
+		r.syncPeerConnection(sink, tracks)
+	}
+}
 
-			if err = pcState.websocket.WriteJSON(&websocketMessage{
-				Event: "offer",
-				Data:  string(offerString),
-			}); err != nil {
-				return true
-			}
+// syncPeerConnection приводит отправляемые участнику треки к TrackLocals комнаты и согласует их с ним.
+// На другие узлы уходят только собственные треки комнаты, пересланные обратно не возвращаются.
+// При ошибке согласование откладывается и повторяется после следующего answer или изменения комнаты.
+// Вызывается под ListLock комнаты.
+func (r *Room) syncPeerConnection(pcState *peerConnectionState, tracks map[string]trackInfo) {
+	existingSenders := map[string]bool{}
+	changed := false
+	failed := false
+
+	wanted := func(trackID string) bool {
+		if _, ok := r.TrackLocals[trackID]; !ok {
+			return false
+		}
+		track, ok := r.Tracks[trackID]
+		return pcState.role != roleRelay || !ok || !track.relayed
+	}
 
-			i++
+	for _, sender := range pcState.peerConnection.GetSenders() {
+		if sender.Track() == nil {
+			continue
+		}
+		existingSenders[sender.Track().ID()] = true
+		if !wanted(sender.Track().ID()) {
+			if err := pcState.peerConnection.RemoveTrack(sender); err != nil {
+				pcState.log.Warn("Failed to remove track", "track", sender.Track().ID(), "err", err)
+				failed = true
+				continue
+			}
+			changed = true
 		}
-		return false
 	}
 
-	for syncAttempt := 0; ; syncAttempt++ {
-		if syncAttempt == 25 {
-			go func() {
-				time.Sleep(time.Second * 3)
-				r.signalPeerConnections()
-			}()
-			return
+	for _, receiver := range pcState.peerConnection.GetReceivers() {
+		if receiver.Track() == nil {
+			continue
 		}
-		if !attemptSync() {
-			break
+		existingSenders[receiver.Track().ID()] = true
+	}
+
+	for trackID := range r.TrackLocals {
+		if _, ok := existingSenders[trackID]; !ok && wanted(trackID) {
+			if _, err := pcState.peerConnection.AddTrack(r.TrackLocals[trackID]); err != nil {
+				pcState.log.Warn("Failed to add track", "track", trackID, "err", err)
+				failed = true
+				continue
+			}
+			changed = true
 		}
 	}
+
+	if err := pcState.negotiate(tracks, changed); err != nil {
+		pcState.log.Warn("Failed to negotiate", "err", err)
+		failed = true
+	}
+
+	if failed {
+		pcState.markPending()
+	}
 }
 
 func (r *Room) DispatchKeyFrame() {
-	r.ListLock.Lock()
-	defer r.ListLock.Unlock()
-	for i := range r.Peers {
-		for _, receiver := range r.Peers[i].peerConnection.GetReceivers() {
+	r.ListLock.RLock()
+	defer r.ListLock.RUnlock()
+
+	presenting := r.presenting()
+	for _, peer := range r.publishingPeers() {
+		for _, receiver := range peer.peerConnection.GetReceivers() {
 			if receiver.Track() == nil {
 				continue
 			}
-			_ = r.Peers[i].peerConnection.WriteRTCP([]rtcp.Packet{
+
+			packets := []rtcp.Packet{
 				&rtcp.PictureLossIndication{MediaSSRC: uint32(receiver.Track().SSRC())},
-			})
+			}
+			if remb := r.presenterPriority(receiver.Track(), presenting); remb != nil {
+				packets = append(packets, remb)
+			}
+
+			_ = peer.peerConnection.WriteRTCP(packets)
 		}
 	}
 }
 
+// websocketMessage — сообщение устаревшего протокола v0
 type websocketMessage struct {
-	Event  string `json:"event"`
-	Data   string `json:"data"`
-	Sender string `json:"sender,omitempty"`
-	Text   string `json:"text,omitempty"`
+	Event  string               `json:"event"`
+	Data   string               `json:"data"`
+	Sender string               `json:"sender,omitempty"`
+	Text   string               `json:"text,omitempty"`
+	ID     uint64               `json:"id,omitempty"`     // Номер сообщения чата
+	Tracks map[string]trackInfo `json:"tracks,omitempty"` // Метаданные треков, приходят вместе с offer
 }
 
 type peerConnectionState struct {
+	id             string
 	peerConnection *webrtc.PeerConnection
-	websocket      *threadSafeWriter
-	username       string // Добавляем имя пользователя
+	log            *slog.Logger                     // Логгер с полями room и peer
+	websocket      atomic.Pointer[threadSafeWriter] // Заменяется при возобновлении сессии
+	username       string                           // Добавляем имя пользователя
+	role           string
+	dataChannels   dataChannelSet
+	screenShare    *webrtc.RTPTransceiver // Трансивер демонстрации экрана, защищён ListLock комнаты
+	offers         chan string            // Offer'ы повторного согласования для зрителя WHEP
+	ended          chan struct{}          // Закрывается с завершением сессии WHEP, прерывая поток событий
+	relay          *relayLink             // Сигнализация соединения пересылки между узлами
+	negotiation    negotiator
+	recovery       recoveryState
+
+	resumeToken string      // Секрет для возобновления сессии после обрыва websocket
+	resumeTimer *time.Timer // Закрывает отключившуюся сессию по истечении ожидания, защищён ListLock
+	chatMark    uint64      // Последнее сообщение чата на момент обрыва
+}
+
+// sendOffer доставляет offer участнику: websocket-участнику событием offer,
+// зрителю WHEP — в поток событий его сессии, заменяя ещё не прочитанный offer
+func (p *peerConnectionState) sendOffer(offer webrtc.SessionDescription, tracks map[string]trackInfo) error {
+	if p.offers != nil {
+		for {
+			select {
+			case p.offers <- offer.SDP:
+				return nil
+			default:
+				select {
+				case <-p.offers:
+				default:
+				}
+			}
+		}
+	}
+
+	if p.relay != nil {
+		return p.relay.send("offer", offerPayload{Description: offer, Tracks: tracks})
+	}
+
+	return p.websocket.Load().WriteEvent("offer", offerPayload{Description: offer, Tracks: tracks})
+}
+
+// sendAnswer доставляет answer на offer участника или узла-источника пересылки
+func (p *peerConnectionState) sendAnswer(answer *webrtc.SessionDescription) error {
+	if p.relay != nil {
+		return p.relay.send("answer", answer)
+	}
+
+	return p.websocket.Load().WriteEvent("answer", answer)
 }
 
 // Обработчик создания комнаты
-func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if s.rejectDraining(w) {
+		return
+	}
+
 	var req CreateRoomRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -275,22 +422,41 @@ func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	RoomsLock.Lock()
-	defer RoomsLock.Unlock()
+	if req.MaxScreenShares < 0 {
+		http.Error(w, "max_screen_shares must not be negative", http.StatusBadRequest)
+		return
+	}
 
-	if _, exists := Rooms[req.Name]; exists {
+	// В кластере имя комнаты уникально для всех узлов
+	if s.cluster != nil {
+		_, local, err := s.cluster.ClaimRoom(r.Context(), req.Name)
+		if err != nil {
+			s.requestLog(r).Error("Failed to claim room", "room", req.Name, "err", err)
+			http.Error(w, "Cluster unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if !local {
+			http.Error(w, "Room already exists", http.StatusConflict)
+			return
+		}
+	}
+
+	s.roomsLock.Lock()
+	defer s.roomsLock.Unlock()
+
+	if _, exists := s.rooms[req.Name]; exists {
 		http.Error(w, "Room already exists", http.StatusConflict)
 		return
 	}
 
-	// Создаем комнату
-	room := &Room{
-		Name:        req.Name,
-		TrackLocals: make(map[string]*webrtc.TrackLocalStaticRTP),
-		ChatHistory: make([]ChatMessage, 0),
+	if req.MaxScreenShares == 0 {
+		req.MaxScreenShares = s.cfg.Limits.MaxScreenShares
 	}
-	Rooms[req.Name] = room
-	roomPasswords[req.Name] = req.Password
+
+	// Создаем комнату
+	room := s.newRoom(req.Name, req.MaxScreenShares)
+	s.rooms[req.Name] = room
+	s.passwords[req.Name] = req.Password
 
 	w.Header().Set("Content-Type", "application/json")
 	// "uri": fmt.Sprintf("https://3449009-eq23140.twc1.net/?room=%s&password=%s",
@@ -305,22 +471,38 @@ func CreateRoomHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // Обработчик проверки комнаты
-func CheckRoomHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) CheckRoomHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if s.rejectDraining(w) {
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
 	var req JoinRoomRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	RoomsLock.RLock()
-	defer RoomsLock.RUnlock()
+	// Владельцу комнаты уходит исходное тело запроса
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if s.forwardToRoomOwner(w, r, req.Name, true) {
+		return
+	}
 
-	password, exists := roomPasswords[req.Name]
+	s.roomsLock.RLock()
+	defer s.roomsLock.RUnlock()
+
+	password, exists := s.passwords[req.Name]
 	if !exists {
 		http.Error(w, "Room does not exist", http.StatusNotFound)
 		return
@@ -339,9 +521,7 @@ func CheckRoomHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // websocketHandler с проверкой пароля
-func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
+func (s *Server) WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 	roomName := r.URL.Query().Get("room")
 	username := r.URL.Query().Get("username")
 	password := r.URL.Query().Get("password")
@@ -351,10 +531,19 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if s.forwardToRoomOwner(w, r, roomName, true) {
+		return
+	}
+
+	// Возобновление сессии допускается и во время остановки узла
+	if r.URL.Query().Get("resume") == "" && s.rejectDraining(w) {
+		return
+	}
+
 	// Проверяем пароль комнаты
-	RoomsLock.RLock()
-	roomPassword, roomExists := roomPasswords[roomName]
-	RoomsLock.RUnlock()
+	s.roomsLock.RLock()
+	roomPassword, roomExists := s.passwords[roomName]
+	s.roomsLock.RUnlock()
 
 	if !roomExists {
 		http.Error(w, "Room does not exist", http.StatusNotFound)
@@ -370,51 +559,103 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 		username = "anonymous"
 	}
 
-	RoomsLock.Lock()
-	room, ok := Rooms[roomName]
+	s.roomsLock.RLock()
+	room, ok := s.rooms[roomName]
+	s.roomsLock.RUnlock()
+
 	if !ok {
-		// Это не должно происходить, так как мы уже проверили roomPasswords
+		// Это не должно происходить, так как мы уже проверили пароль комнаты
 		http.Error(w, "Room configuration error", http.StatusInternalServerError)
-		RoomsLock.Unlock()
 		return
 	}
-	RoomsLock.Unlock()
 
-	unsafeConn, err := upgrader.Upgrade(w, r, nil)
+	log := room.requestLog(r)
+
+	unsafeConn, err := s.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Errorf("Failed to upgrade HTTP to Websocket: ", err)
+		log.Error("Failed to upgrade HTTP to Websocket", "err", err)
+		return
+	}
+
+	c := s.newThreadSafeWriter(unsafeConn, log)
+	defer c.Close()
+
+	// Переподключение в пределах resumeGracePeriod возвращает участника в его сессию
+	if token := r.URL.Query().Get("resume"); token != "" {
+		lastChat, _ := strconv.ParseUint(r.URL.Query().Get("last_chat"), 10, 64)
+
+		pcState, err := room.resumeSession(token, c, lastChat)
+		if err != nil {
+			log.Info("Failed to resume session", "err", err)
+
+			if err := c.WriteError("", err); err != nil {
+				log.Error("Failed to send error", "err", err)
+			}
+			return
+		}
+
+		room.detachSession(pcState, c, room.serveSignaling(pcState, c))
 		return
 	}
 
-	c := &threadSafeWriter{unsafeConn, sync.Mutex{}}
+	resumeToken, err := newResumeToken()
+	if err != nil {
+		log.Error("Failed to generate resume token", "err", err)
+		return
+	}
 
 	// Отправляем историю чата новому участнику
 	if err := room.sendChatHistory(c); err != nil {
-		log.Errorf("Failed to send chat history: %v", err)
+		log.Error("Failed to send chat history", "err", err)
 	}
 
-	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	peerConnection, err := s.api.NewPeerConnection(webrtc.Configuration{})
 	if err != nil {
-		log.Errorf("Failed to creates a PeerConnection: %v", err)
-		c.Close()
+		log.Error("Failed to create PeerConnection", "err", err)
 		return
 	}
 
-	defer peerConnection.Close()
-
 	for _, typ := range []webrtc.RTPCodecType{webrtc.RTPCodecTypeVideo, webrtc.RTPCodecTypeAudio} {
 		if _, err := peerConnection.AddTransceiverFromKind(typ, webrtc.RTPTransceiverInit{
 			Direction: webrtc.RTPTransceiverDirectionRecvonly,
 		}); err != nil {
-			log.Errorf("Failed to add transceiver: %v", err)
-			c.Close()
+			log.Error("Failed to add transceiver", "err", err)
+			peerConnection.Close()
 			return
 		}
 	}
 
-	RoomsLock.Lock()
-	room.Peers = append(room.Peers, peerConnectionState{peerConnection, c, username})
-	RoomsLock.Unlock()
+	pcState := &peerConnectionState{
+		id:             uuid.NewString(),
+		peerConnection: peerConnection,
+		username:       username,
+		role:           roleParticipant,
+		resumeToken:    resumeToken,
+	}
+	pcState.log = log.With("peer", pcState.id)
+	pcState.websocket.Store(c)
+
+	pcState.log.Info("Participant joined", "username", username)
+
+	// Каналы данных для сообщений приложения (доска, курсоры и т.п.)
+	if err := room.openDataChannels(pcState); err != nil {
+		pcState.log.Error("Failed to create data channels", "err", err)
+		peerConnection.Close()
+		return
+	}
+
+	peerConnection.OnDataChannel(func(d *webrtc.DataChannel) {
+		room.attachDataChannel(pcState, d)
+	})
+
+	if err := pcState.sendSession(); err != nil {
+		pcState.log.Error("Failed to send session", "err", err)
+	}
+	if err := s.sendICEServers(pcState); err != nil {
+		pcState.log.Error("Failed to send ICE servers", "err", err)
+	}
+
+	room.addPeer(pcState)
 
 	// Trickle ICE. Передача кандидата сервера клиенту
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
@@ -423,145 +664,174 @@ func WebsocketHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		// Если вы сериализуете кандидата, обязательно используйте ToJSON
 		// Использование Marshal приведет к ошибкам вокруг `sdpMid`
-		candidateString, err := json.Marshal(i.ToJSON())
-		if err != nil {
-			log.Errorf("Failed to marshal candidate to json: %v", err)
-			return
-		}
+		candidate := i.ToJSON()
 
-		log.Infof("Send candidate to client: %s", candidateString)
+		pcState.log.Debug("Send candidate to client", "candidate", candidate.Candidate)
 
-		if writeErr := c.WriteJSON(&websocketMessage{
-			Event: "candidate",
-			Data:  string(candidateString),
-		}); writeErr != nil {
-			log.Errorf("Failed to write JSON: %v", writeErr)
+		if writeErr := pcState.websocket.Load().WriteEvent("candidate", candidate); writeErr != nil {
+			pcState.log.Error("Failed to write JSON", "err", writeErr)
 		}
 	})
 
 	// Если PeerConnection закрыт, удалите его из глобального списка.
 	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
-		log.Infof("Connection state change: %s", p)
+		pcState.log.Info("Connection state change", "state", p.String())
+
+		// При сбое ICE перезапускается, участник закрывается после исчерпания попыток
+		room.handleConnectionState(pcState, p)
 
 		switch p {
-		case webrtc.PeerConnectionStateFailed:
-			if err := peerConnection.Close(); err != nil {
-				log.Errorf("Failed to close PeerConnection: %v", err)
-			}
 		case webrtc.PeerConnectionStateClosed:
+			// Сессия закрыта — её websocket больше не нужен
+			pcState.websocket.Load().Close()
 			room.signalPeerConnections()
 		default:
 		}
 	})
 
-	peerConnection.OnTrack(func(t *webrtc.TrackRemote, _ *webrtc.RTPReceiver) {
-		log.Infof("Got remote track: Kind=%s, ID=%s, PayloadType=%d", t.Kind(), t.ID(), t.PayloadType())
-
-		// Create a track to fan out our incoming video to all peers
-		trackLocal := room.addTrack(t)
-		defer room.removeTrack(trackLocal)
-
-		buf := make([]byte, 1500)
-		rtpPkt := &rtp.Packet{}
-
-		for {
-			i, _, err := t.Read(buf)
-			if err != nil {
-				return
-			}
-
-			if err = rtpPkt.Unmarshal(buf[:i]); err != nil {
-				log.Errorf("Failed to unmarshal incoming RTP packet: %v", err)
-				return
-			}
-
-			rtpPkt.Extension = false
-			rtpPkt.Extensions = nil
-
-			if err = trackLocal.WriteRTP(rtpPkt); err != nil {
-				return
-			}
-		}
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		room.forwardTrack(pcState, t, receiver)
 	})
 
 	peerConnection.OnICEConnectionStateChange(func(is webrtc.ICEConnectionState) {
-		log.Infof("ICE connection state changed: %s", is)
+		pcState.log.Debug("ICE connection state changed", "state", is.String())
 	})
 
 	// Signal for the new PeerConnection
 	room.signalPeerConnections()
 
-	message := &websocketMessage{}
+	room.detachSession(pcState, c, room.serveSignaling(pcState, c))
+}
+
+// serveSignaling читает и выполняет сообщения участника, пока websocket c не закроется.
+// Возвращает ошибку чтения, по которой видно, закрыл ли клиент соединение сам.
+func (r *Room) serveSignaling(pcState *peerConnectionState, c *threadSafeWriter) error {
 	for {
 		_, raw, err := c.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Errorf("WebSocket read error: %v", err)
+				pcState.log.Error("WebSocket read error", "err", err)
 			}
-			break
+			return err
 		}
 
-		log.Infof("Got message: %s", raw)
+		// Сообщения содержат SDP и кандидаты, поэтому целиком пишутся только на уровне trace
+		pcState.log.Log(context.Background(), logger.LevelTrace, "Got message", "message", raw)
 
-		if err := json.Unmarshal(raw, &message); err != nil {
-			log.Errorf("Failed to unmarshal json to message: %v", err)
-			continue
-		}
+		signal, err := c.decodeSignal(raw)
+		if err != nil {
+			pcState.log.Error("Failed to unmarshal json to message", "err", err)
 
-		switch message.Event {
-		case "candidate":
-			candidate := webrtc.ICECandidateInit{}
-			if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-				log.Errorf("Failed to unmarshal json to candidate: %v", err)
-				continue
+			if err := c.WriteError("", newSignalingError(errCodeBadRequest, err)); err != nil {
+				pcState.log.Error("Failed to send error", "err", err)
 			}
+			continue
+		}
 
-			log.Infof("Got candidate: %v", candidate)
+		if err := r.handleSignal(pcState, signal); err != nil {
+			pcState.log.Error("Failed to handle message", "type", signal.Type, "err", err)
 
-			if err := peerConnection.AddICECandidate(candidate); err != nil {
-				log.Errorf("Failed to add ICE candidate: %v", err)
-				continue
-			}
-		case "answer":
-			answer := webrtc.SessionDescription{}
-			if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
-				log.Errorf("Failed to unmarshal json to answer: %v", err)
-				continue
+			if err := c.WriteError(signal.RequestID, err); err != nil {
+				pcState.log.Error("Failed to send error", "err", err)
 			}
+			continue
+		}
 
-			log.Infof("Got answer: %v", answer)
-
-			if err := peerConnection.SetRemoteDescription(answer); err != nil {
-				log.Errorf("Failed to set remote description: %v", err)
-				continue
-			}
-		case "chat":
-			// Добавляем сообщение в историю комнаты
-			room.addChatMessage(message.Sender, message.Text)
-
-			// Рассылаем сообщение всем участникам комнаты
-			room.ListLock.RLock()
-			for _, peer := range room.Peers {
-				if err := peer.websocket.WriteJSON(message); err != nil {
-					log.Errorf("Failed to send chat message: %v", err)
-				}
-			}
-			room.ListLock.RUnlock()
-		default:
-			log.Errorf("unknown message: %+v", message)
+		if err := c.WriteAck(signal.RequestID); err != nil {
+			pcState.log.Error("Failed to send ack", "err", err)
 		}
 	}
 }
 
-// Helper to make Gorilla Websockets threadsafe
+// Helper to make Gorilla Websockets threadsafe.
+// Сообщения кладутся в очередь и пишутся отдельной горутиной, поэтому рассылка
+// под блокировкой комнаты не ждёт медленного клиента. Переполнение очереди закрывает соединение.
 type threadSafeWriter struct {
 	*websocket.Conn
-	sync.Mutex
+
+	protocol string // Согласованный подпротокол сигнализации, пустой для v0
+
+	outbound  chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+
+	log *slog.Logger
 }
 
+func (s *Server) newThreadSafeWriter(conn *websocket.Conn, log *slog.Logger) *threadSafeWriter {
+	t := &threadSafeWriter{
+		Conn:     conn,
+		protocol: conn.Subprotocol(),
+		outbound: make(chan []byte, s.cfg.Limits.OutboundQueueSize),
+		done:     make(chan struct{}),
+		log:      log,
+	}
+	go t.writeLoop()
+
+	return t
+}
+
+// WriteJSON кодирует сообщение сразу и ставит его в очередь отправки без ожидания сети
 func (t *threadSafeWriter) WriteJSON(v interface{}) error {
-	t.Lock()
-	defer t.Unlock()
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case <-t.done:
+		return errWriterClosed
+	default:
+	}
+
+	select {
+	case t.outbound <- data:
+		return nil
+	default:
+		t.log.Warn("Outbound queue overflow, closing slow websocket", "remote", t.RemoteAddr().String())
+		t.Close()
+		return errSlowConsumer
+	}
+}
+
+func (t *threadSafeWriter) writeLoop() {
+	defer t.Conn.Close()
+
+	for {
+		select {
+		case data := <-t.outbound:
+			t.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := t.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				t.log.Error("Failed to write to websocket", "err", err)
+				t.closeOnce.Do(func() { close(t.done) })
+				return
+			}
+		case <-t.done:
+			t.flush()
+			return
+		}
+	}
+}
+
+// flush дописывает оставшиеся в очереди сообщения перед закрытием соединения
+func (t *threadSafeWriter) flush() {
+	t.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+	for {
+		select {
+		case data := <-t.outbound:
+			if err := t.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Close останавливает очередь; соединение закрывается после отправки уже поставленных сообщений,
+// и цикл чтения обработчика завершается
+func (t *threadSafeWriter) Close() error {
+	t.closeOnce.Do(func() { close(t.done) })
 
-	return t.Conn.WriteJSON(v)
+	return nil
 }
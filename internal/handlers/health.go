@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// Проверки для оркестратора: /healthz — процесс жив, /readyz — узел может принимать участников,
+// /debug/status — сводка состояния узла.
+
+// Предельное время одной проверки зависимости в /readyz
+const readinessCheckTimeout = 2 * time.Second
+
+// readinessResponse — ответ /readyz: итог и результат каждой проверки ("ok" или текст ошибки)
+type readinessResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+// statusResponse — ответ /debug/status
+type statusResponse struct {
+	Uptime     string    `json:"uptime"`
+	StartedAt  time.Time `json:"started_at"`
+	Draining   bool      `json:"draining"`
+	Node       string    `json:"node,omitempty"` // Идентификатор узла в кластере
+	Rooms      int       `json:"rooms"`
+	Peers      int       `json:"peers"`      // Участники с websocket
+	Publishers int       `json:"publishers"` // Сессии WHIP
+	Viewers    int       `json:"viewers"`    // Сессии WHEP
+	Relays     int       `json:"relays"`     // Пересылки между узлами в обе стороны
+	Build      buildInfo `json:"build"`
+}
+
+type buildInfo struct {
+	GoVersion string `json:"go_version"`
+	Version   string `json:"version,omitempty"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // Собран из изменённого рабочего дерева
+}
+
+// HealthzHandler отвечает, пока процесс обслуживает HTTP
+func (s *Server) HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write([]byte("ok"))
+}
+
+// ReadyzHandler проверяет, что узел не останавливается, а Postgres доступен.
+// 503 выводит узел из балансировки, не перезапуская его.
+func (s *Server) ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessCheckTimeout)
+	defer cancel()
+
+	response := readinessResponse{Status: "ok", Checks: make(map[string]string)}
+	check := func(name string, err error) {
+		if err != nil {
+			response.Status = "unavailable"
+			response.Checks[name] = err.Error()
+			return
+		}
+		response.Checks[name] = "ok"
+	}
+
+	if s.draining.Load() {
+		check("draining", errDraining)
+	} else {
+		check("draining", nil)
+	}
+
+	if s.db != nil {
+		check("postgres", s.db.Ping(ctx))
+	}
+
+	status := http.StatusOK
+	if response.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+// StatusHandler возвращает время работы, число комнат и соединений и сведения о сборке
+func (s *Server) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	status := statusResponse{
+		Uptime:    time.Since(s.startedAt).Round(time.Second).String(),
+		StartedAt: s.startedAt,
+		Draining:  s.draining.Load(),
+		Build:     readBuildInfo(),
+	}
+	if s.cluster != nil {
+		status.Node = s.cluster.Self.ID
+	}
+
+	for _, room := range s.roomList() {
+		room.ListLock.RLock()
+		status.Rooms++
+		status.Peers += len(room.Peers)
+		status.Publishers += len(room.Publishers)
+		status.Viewers += len(room.Viewers)
+		status.Relays += len(room.RelaySinks) + len(room.RelaySources)
+		room.ListLock.RUnlock()
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// readBuildInfo берёт версию модуля и ревизию VCS, записанные go build
+func readBuildInfo() buildInfo {
+	info := buildInfo{GoVersion: runtime.Version()}
+
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+
+	if build.Main.Version != "(devel)" {
+		info.Version = build.Main.Version
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			info.Revision = setting.Value
+		case "vcs.time":
+			info.Time = setting.Value
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"webrtc-app/internal/config"
+)
+
+// /readyz зависит только от остановки узла и Postgres: токены узел не проверяет,
+// поэтому недоступный AUTH_TOKEN_URL не выводит его из балансировки
+func TestReadyz(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.TokenURL = "http://127.0.0.1:1/check_token/"
+	})
+
+	tests := []struct {
+		name     string
+		draining bool
+		status   int
+	}{
+		{name: "ready", status: http.StatusOK},
+		{name: "draining", draining: true, status: http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s.draining.Store(test.draining)
+
+			recorder := httptest.NewRecorder()
+			s.ReadyzHandler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if recorder.Code != test.status {
+				t.Fatalf("status %d: %s", recorder.Code, recorder.Body)
+			}
+
+			var response readinessResponse
+			if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if len(response.Checks) != 1 || response.Checks["draining"] == "" {
+				t.Fatalf("unexpected checks %+v", response.Checks)
+			}
+		})
+	}
+}
@@ -0,0 +1,295 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"webrtc-app/internal/config"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// Предельное время ожидания события в тестах
+const testTimeout = 10 * time.Second
+
+// newTestServer создаёт одиночный узел без STUN-серверов, чтобы сбор кандидатов не ходил в сеть
+func newTestServer(t *testing.T, options ...func(*config.Config)) *Server {
+	t.Helper()
+
+	s, err := NewServer(testConfig(t, options...), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	return s
+}
+
+// testConfig возвращает настройки по умолчанию без STUN-серверов и проверки токенов
+func testConfig(t *testing.T, options ...func(*config.Config)) *config.Config {
+	t.Helper()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.ICE.STUNServers = nil
+	cfg.Log.Level = "error"
+	for _, option := range options {
+		option(cfg)
+	}
+
+	return cfg
+}
+
+// serveTestServer обслуживает маршруты узла на httptest-листенере, как cmd/main.go
+func serveTestServer(t *testing.T, s *Server) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/create-room", s.EnableCORS(s.CreateRoomHandler))
+	mux.HandleFunc("/api/check-room", s.EnableCORS(s.CheckRoomHandler))
+	mux.HandleFunc("/websocket", s.EnableCORS(s.WebsocketHandler))
+	mux.HandleFunc("/whip/{room}", s.EnableCORS(s.WHIPHandler))
+	mux.HandleFunc("/whip/{room}/{session}", s.EnableCORS(s.WHIPResourceHandler))
+	mux.HandleFunc("/whep/{room}", s.EnableCORS(s.WHEPHandler))
+	mux.HandleFunc("/whep/{room}/{session}", s.EnableCORS(s.WHEPResourceHandler))
+	mux.HandleFunc("/relay/{room}", s.RelayHandler)
+	mux.HandleFunc("/api/relay", s.StartRelayHandler)
+
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+// createTestRoom создаёт комнату с паролем "pw" и возвращает её
+func createTestRoom(t *testing.T, s *Server, baseURL, name string) *Room {
+	t.Helper()
+
+	response, err := http.Post(baseURL+"/api/create-room", "application/json", strings.NewReader(`{"name":"`+name+`","password":"pw"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("create room: %s", response.Status)
+	}
+
+	return s.testRoom(name)
+}
+
+func (s *Server) testRoom(name string) *Room {
+	s.roomsLock.RLock()
+	defer s.roomsLock.RUnlock()
+
+	return s.rooms[name]
+}
+
+// addTestTrack публикует в комнате видеотрек без участника-владельца
+func addTestTrack(t *testing.T, room *Room, id string) *webrtc.TrackLocalStaticRTP {
+	t.Helper()
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeVP8}, id, id+"-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	room.ListLock.Lock()
+	room.TrackLocals[id] = track
+	room.Tracks[id] = &trackInfo{TrackID: id, StreamID: id + "-stream", ParticipantID: "test", Kind: "video", Source: trackSourceCamera, paused: &atomic.Bool{}}
+	room.ListLock.Unlock()
+
+	room.signalPeerConnections()
+
+	return track
+}
+
+// testClient — участник на pion, подключённый по websocket с протоколом v1
+type testClient struct {
+	t        *testing.T
+	ws       *websocket.Conn
+	pc       *webrtc.PeerConnection
+	messages chan signalingMessage
+}
+
+func dialTestClient(t *testing.T, baseURL, room string) *testClient {
+	t.Helper()
+
+	dialer := websocket.Dialer{Subprotocols: []string{signalingProtocolV1}}
+	ws, _, err := dialer.Dial(strings.Replace(baseURL, "http", "ws", 1)+"/websocket?room="+room+"&password=pw&username=tester", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &testClient{t: t, ws: ws, pc: pc, messages: make(chan signalingMessage, 64)}
+	t.Cleanup(func() {
+		ws.Close()
+		pc.Close()
+	})
+
+	go func() {
+		defer close(c.messages)
+		for {
+			var message signalingMessage
+			if err := ws.ReadJSON(&message); err != nil {
+				return
+			}
+			c.messages <- message
+		}
+	}()
+
+	return c
+}
+
+// next ждёт сообщение одного из типов, остальные пропускает
+func (c *testClient) next(types ...string) signalingMessage {
+	c.t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case message, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("websocket closed while waiting for %v", types)
+			}
+			for _, typ := range types {
+				if message.Type == typ {
+					return message
+				}
+			}
+		case <-deadline:
+			c.t.Fatalf("no %v message", types)
+		}
+	}
+}
+
+// expectNo проверяет, что сообщения типа typ не приходит за время wait
+func (c *testClient) expectNo(typ string, wait time.Duration) {
+	c.t.Helper()
+
+	deadline := time.After(wait)
+	for {
+		select {
+		case message, ok := <-c.messages:
+			if !ok {
+				return
+			}
+			if message.Type == typ {
+				c.t.Fatalf("unexpected %s message", typ)
+			}
+		case <-deadline:
+			return
+		}
+	}
+}
+
+func (c *testClient) send(typ string, payload interface{}) {
+	c.t.Helper()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.ws.WriteJSON(signalingMessage{Type: typ, Payload: data}); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// offer читает offer сервера
+func (c *testClient) offer() offerPayload {
+	c.t.Helper()
+
+	var offer offerPayload
+	if err := json.Unmarshal(c.next("offer").Payload, &offer); err != nil {
+		c.t.Fatal(err)
+	}
+
+	return offer
+}
+
+// answer применяет offer сервера и отправляет answer
+func (c *testClient) answer(offer offerPayload) {
+	c.t.Helper()
+
+	if err := c.respond(offer); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// respond — answer без остановки теста, для вызова из других горутин
+func (c *testClient) respond(offer offerPayload) error {
+	if err := c.pc.SetRemoteDescription(offer.Description); err != nil {
+		return err
+	}
+	answer, err := c.pc.CreateAnswer(nil)
+	if err != nil {
+		return err
+	}
+	if err := c.pc.SetLocalDescription(answer); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(answer)
+	if err != nil {
+		return err
+	}
+
+	return c.ws.WriteJSON(signalingMessage{Type: "answer", Payload: data})
+}
+
+// sendOffer создаёт и отправляет offer клиента
+func (c *testClient) sendOffer() {
+	c.t.Helper()
+
+	offer, err := c.pc.CreateOffer(nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.pc.SetLocalDescription(offer); err != nil {
+		c.t.Fatal(err)
+	}
+	c.send("offer", offer)
+}
+
+// acceptAnswer применяет answer сервера на offer клиента
+func (c *testClient) acceptAnswer() {
+	c.t.Helper()
+
+	var answer webrtc.SessionDescription
+	if err := json.Unmarshal(c.next("answer").Payload, &answer); err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.pc.SetRemoteDescription(answer); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// sentTracks считает треки, которые сервер отправляет по SDP
+func sentTracks(sdp string) int {
+	return strings.Count(sdp, "a=msid:")
+}
+
+// waitFor ждёт выполнения условия
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(testTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
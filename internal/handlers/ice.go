@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"webrtc-app/internal/config"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+)
+
+// Размер буфера чтения ICE-TCP на одно соединение, в пакетах
+const iceTCPReadBufferSize = 8
+
+// iceServerInfo — ICE-сервер в формате RTCIceServer браузера
+type iceServerInfo struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// configureWebRTC собирает API для PeerConnection узла из настроек ICE:
+// NAT 1:1, диапазон UDP-портов, мультиплексоры и фильтр интерфейсов.
+// STUN и TURN выдаются клиентам; адреса самого сервера задаются NAT 1:1,
+// поэтому его PeerConnection обходятся без ICE-серверов и не ждут ответа STUN при сборе кандидатов.
+func (s *Server) configureWebRTC(cfg config.ICEConfig) error {
+	settingEngine := webrtc.SettingEngine{LoggerFactory: s.loggerFactory}
+
+	if len(cfg.NAT1To1IPs) > 0 {
+		settingEngine.SetNAT1To1IPs(cfg.NAT1To1IPs, webrtc.ICECandidateTypeHost)
+	}
+
+	if cfg.UDPPortMin != 0 {
+		if err := settingEngine.SetEphemeralUDPPortRange(cfg.UDPPortMin, cfg.UDPPortMax); err != nil {
+			return err
+		}
+	}
+
+	// Фильтр интерфейсов применяется и к сбору кандидатов, и к мультиплексору UDP
+	var interfaceFilter func(string) bool
+	if len(cfg.Interfaces) > 0 {
+		allowed := make(map[string]bool, len(cfg.Interfaces))
+		for _, name := range cfg.Interfaces {
+			allowed[name] = true
+		}
+		interfaceFilter = func(name string) bool {
+			return allowed[name]
+		}
+		settingEngine.SetInterfaceFilter(interfaceFilter)
+	}
+
+	// Все соединения на одном UDP-порту: достаточно открыть один порт на firewall или в Service.
+	// Порт слушается на каждом адресе хоста, чтобы кандидаты совпадали с адресами, с которых уходят ответы.
+	if cfg.UDPMuxPort != 0 {
+		options := []ice.UDPMuxFromPortOption{ice.UDPMuxFromPortWithLogger(s.loggerFactory.NewLogger("ice"))}
+		if interfaceFilter != nil {
+			options = append(options, ice.UDPMuxFromPortWithInterfaceFilter(interfaceFilter))
+		}
+
+		udpMux, err := ice.NewMultiUDPMuxFromPort(int(cfg.UDPMuxPort), options...)
+		if err != nil {
+			return err
+		}
+		settingEngine.SetICEUDPMux(udpMux)
+		s.iceListeners = append(s.iceListeners, udpMux)
+
+		s.log.Info("ICE UDP mux listening", "port", cfg.UDPMuxPort, "addrs", len(udpMux.GetListenAddresses()))
+	}
+
+	// Пассивные кандидаты ICE-TCP для сетей, где UDP заблокирован
+	if cfg.TCPPort != 0 {
+		tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: int(cfg.TCPPort)})
+		if err != nil {
+			return err
+		}
+		tcpMux := webrtc.NewICETCPMux(s.loggerFactory.NewLogger("ice"), tcpListener, iceTCPReadBufferSize)
+		settingEngine.SetICETCPMux(tcpMux)
+		s.iceListeners = append(s.iceListeners, tcpMux)
+		settingEngine.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4, webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4, webrtc.NetworkTypeTCP6,
+		})
+
+		s.log.Info("ICE-TCP listening", "addr", tcpListener.Addr().String())
+	}
+
+	if len(cfg.TURNServers) > 0 && cfg.TURNSecret == "" {
+		s.log.Warn("TURN servers are configured without ICE_TURN_SECRET, clients will not get TURN credentials")
+	}
+
+	s.api = webrtc.NewAPI(webrtc.WithSettingEngine(settingEngine))
+
+	return nil
+}
+
+// clientICEServers возвращает ICE-серверы для клиента. Учётные данные TURN выдаются по схеме TURN REST API:
+// username — "<срок действия unix>:<id участника>", credential — turnPassword(username).
+func (s *Server) clientICEServers(participantID string) []iceServerInfo {
+	servers := make([]iceServerInfo, 0, 2)
+
+	if len(s.cfg.ICE.STUNServers) > 0 {
+		servers = append(servers, iceServerInfo{URLs: s.cfg.ICE.STUNServers})
+	}
+
+	if urls := s.turnServerURLs(); len(urls) > 0 && s.cfg.ICE.TURNSecret != "" {
+		username := strconv.FormatInt(time.Now().Add(s.cfg.ICE.TURNTTL).Unix(), 10) + ":" + participantID
+
+		servers = append(servers, iceServerInfo{
+			URLs:       urls,
+			Username:   username,
+			Credential: s.turnPassword(username),
+		})
+	}
+
+	return servers
+}
+
+// sendICEServers отправляет участнику ICE-серверы до первого offer
+func (s *Server) sendICEServers(p *peerConnectionState) error {
+	return p.websocket.Load().WriteEvent("ice_servers", s.clientICEServers(p.id))
+}
+
+// setICEServerLinks добавляет ICE-серверы в ответ WHIP/WHEP заголовками Link (RFC 9725, раздел 4.6)
+func (s *Server) setICEServerLinks(w http.ResponseWriter, participantID string) {
+	for _, server := range s.clientICEServers(participantID) {
+		for _, url := range server.URLs {
+			link := fmt.Sprintf(`<%s>; rel="ice-server"`, url)
+			if server.Username != "" {
+				link += fmt.Sprintf(`; username="%s"; credential="%s"; credential-type="password"`, server.Username, server.Credential)
+			}
+			w.Header().Add("Link", link)
+		}
+	}
+}
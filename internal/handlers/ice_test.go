@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"net"
+	"testing"
+
+	"webrtc-app/internal/config"
+)
+
+// freeTestPort возвращает порт, свободный для TCP и UDP
+func freeTestPort(t *testing.T) uint16 {
+	t.Helper()
+
+	for range 10 {
+		listener, err := net.ListenTCP("tcp", &net.TCPAddr{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			continue
+		}
+		conn.Close()
+
+		return uint16(port)
+	}
+
+	t.Fatal("no free port")
+	return 0
+}
+
+// Close освобождает порты мультиплексоров ICE: следующий узел занимает те же порты
+func TestServerCloseReleasesICEPorts(t *testing.T) {
+	udpPort, tcpPort := freeTestPort(t), freeTestPort(t)
+	withMuxes := func(cfg *config.Config) {
+		cfg.ICE.UDPMuxPort = udpPort
+		cfg.ICE.TCPPort = tcpPort
+	}
+
+	for range 2 {
+		s, err := NewServer(testConfig(t, withMuxes), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
+var errGlare = errors.New("offer collides with pending server offer")
+
+// negotiator хранит состояние согласования SDP с одним участником по схеме perfect negotiation.
+// Сервер — невежливая сторона (pion не умеет rollback): встречный offer клиента отклоняется ошибкой glare,
+// а клиент откатывает свой offer при получении offer сервера и повторяет его после ответа.
+// Пока offer сервера ждёт ответа, новые изменения только помечаются и уходят одним offer после answer.
+type negotiator struct {
+	sync.Mutex
+	pending    bool // Есть изменения, которые не вошли в отправленный offer
+	iceRestart bool // Следующий offer должен перезапустить ICE
+}
+
+// negotiate отправляет участнику offer с текущим набором трансиверов
+// или откладывает его, если согласование уже идёт. Вызывается под ListLock комнаты.
+func (p *peerConnectionState) negotiate(tracks map[string]trackInfo, changed bool) error {
+	p.negotiation.Lock()
+	defer p.negotiation.Unlock()
+
+	// Зрителей WHEP и другие узлы переспрашиваем только при изменении набора треков
+	if (p.role == roleViewer || p.role == roleRelay) && !changed && !p.negotiation.pending && !negotiationPending(p.peerConnection) {
+		return nil
+	}
+
+	// Offer пересылки уходит по data channel, до его открытия изменения только помечаются
+	if p.peerConnection.SignalingState() != webrtc.SignalingStateStable || (p.relay != nil && !p.relay.ready()) {
+		p.negotiation.pending = true
+		return nil
+	}
+
+	var options *webrtc.OfferOptions
+	if p.negotiation.iceRestart {
+		options = &webrtc.OfferOptions{ICERestart: true}
+	}
+
+	offer, err := p.peerConnection.CreateOffer(options)
+	if err != nil {
+		return err
+	}
+	if err = p.peerConnection.SetLocalDescription(offer); err != nil {
+		return err
+	}
+
+	p.negotiation.pending = false
+	p.negotiation.iceRestart = false
+	return p.sendOffer(offer, tracks)
+}
+
+// markPending откладывает согласование до следующего answer или изменения комнаты
+func (p *peerConnectionState) markPending() {
+	p.negotiation.Lock()
+	p.negotiation.pending = true
+	p.negotiation.Unlock()
+}
+
+// acceptAnswer применяет answer участника на offer сервера и отправляет отложенные изменения
+func (r *Room) acceptAnswer(pcState *peerConnectionState, answer webrtc.SessionDescription) error {
+	pcState.negotiation.Lock()
+	err := pcState.peerConnection.SetRemoteDescription(answer)
+	pending := pcState.negotiation.pending
+	pcState.negotiation.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if pending {
+		r.renegotiate(pcState)
+	}
+
+	return nil
+}
+
+// acceptOffer обрабатывает offer, инициированный участником, и отправляет ему answer.
+// Если offer сервера ещё ждёт ответа, offer клиента игнорируется с ошибкой errGlare.
+func (r *Room) acceptOffer(pcState *peerConnectionState, offer webrtc.SessionDescription) error {
+	peerConnection := pcState.peerConnection
+
+	pcState.negotiation.Lock()
+	answer, err := func() (*webrtc.SessionDescription, error) {
+		if peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+			return nil, errGlare
+		}
+
+		if err := peerConnection.SetRemoteDescription(offer); err != nil {
+			return nil, err
+		}
+
+		answer, err := peerConnection.CreateAnswer(nil)
+		if err != nil {
+			return nil, err
+		}
+		if err = peerConnection.SetLocalDescription(answer); err != nil {
+			return nil, err
+		}
+
+		return peerConnection.LocalDescription(), nil
+	}()
+	pending := pcState.negotiation.pending
+	pcState.negotiation.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if err := pcState.sendAnswer(answer); err != nil {
+		return err
+	}
+
+	if pending {
+		r.renegotiate(pcState)
+	}
+
+	return nil
+}
+
+// renegotiate синхронизирует треки одного участника и отправляет ему offer
+func (r *Room) renegotiate(pcState *peerConnectionState) {
+	r.ListLock.Lock()
+	r.syncPeerConnection(pcState, r.tracksSnapshot())
+	r.ListLock.Unlock()
+}
+
+// resumeNegotiation восстанавливает согласование после возобновления сессии:
+// повторяет offer, ответ на который мог потеряться, и перезапускает ICE, если связь потеряна
+func (r *Room) resumeNegotiation(pcState *peerConnectionState) {
+	peerConnection := pcState.peerConnection
+	iceState := peerConnection.ICEConnectionState()
+	restart := iceState != webrtc.ICEConnectionStateConnected && iceState != webrtc.ICEConnectionStateCompleted
+
+	pcState.negotiation.Lock()
+	if restart {
+		pcState.negotiation.iceRestart = true
+	}
+
+	if peerConnection.SignalingState() == webrtc.SignalingStateHaveLocalOffer {
+		// Перезапуск ICE уйдёт следующим offer после ответа на текущий
+		if restart {
+			pcState.negotiation.pending = true
+		}
+		offer := peerConnection.LocalDescription()
+		pcState.negotiation.Unlock()
+
+		r.ListLock.RLock()
+		tracks := r.tracksSnapshot()
+		r.ListLock.RUnlock()
+
+		if err := pcState.sendOffer(*offer, tracks); err != nil {
+			pcState.log.Error("Failed to resend offer", "err", err)
+		}
+		return
+	}
+	pcState.negotiation.Unlock()
+
+	if restart {
+		r.restartICE(pcState)
+	}
+}
+
+// restartICE отправляет участнику offer с новыми ICE-учётными данными.
+// Если offer сервера ещё ждёт ответа, перезапуск уходит следующим offer.
+func (r *Room) restartICE(pcState *peerConnectionState) {
+	pcState.negotiation.Lock()
+	pcState.negotiation.iceRestart = true
+	stable := pcState.peerConnection.SignalingState() == webrtc.SignalingStateStable
+	if !stable {
+		pcState.negotiation.pending = true
+	}
+	pcState.negotiation.Unlock()
+
+	if stable {
+		r.renegotiate(pcState)
+	}
+}
+
+// negotiationPending сообщает, есть ли у соединения трансиверы, ещё не попавшие в SDP
+func negotiationPending(peerConnection *webrtc.PeerConnection) bool {
+	for _, transceiver := range peerConnection.GetTransceivers() {
+		if transceiver.Mid() == "" {
+			return true
+		}
+	}
+
+	return false
+}
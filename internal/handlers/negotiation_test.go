@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// joinTestPeer подключает участника к новой комнате и возвращает его состояние на сервере
+func joinTestPeer(t *testing.T) (*Room, *testClient, *peerConnectionState) {
+	t.Helper()
+
+	s := newTestServer(t)
+	ts := serveTestServer(t, s)
+	room := createTestRoom(t, s, ts.URL, "negotiation")
+	client := dialTestClient(t, ts.URL, "negotiation")
+
+	var peer *peerConnectionState
+	waitFor(t, "participant", func() bool {
+		room.ListLock.RLock()
+		defer room.ListLock.RUnlock()
+		if len(room.Peers) == 1 {
+			peer = room.Peers[0]
+		}
+		return peer != nil
+	})
+
+	return room, client, peer
+}
+
+func serverSignalingState(peer *peerConnectionState) webrtc.SignalingState {
+	peer.negotiation.Lock()
+	defer peer.negotiation.Unlock()
+
+	return peer.peerConnection.SignalingState()
+}
+
+func negotiationQueued(peer *peerConnectionState) bool {
+	peer.negotiation.Lock()
+	defer peer.negotiation.Unlock()
+
+	return peer.negotiation.pending
+}
+
+// Пока offer сервера ждёт ответа, новые треки не порождают offer, а уходят одним offer после answer
+func TestNegotiationQueuesWhileOfferPending(t *testing.T) {
+	room, client, peer := joinTestPeer(t)
+
+	first := client.offer()
+
+	addTestTrack(t, room, "a")
+	client.expectNo("offer", 300*time.Millisecond)
+	if !negotiationQueued(peer) {
+		t.Fatal("track added during a pending offer was not queued")
+	}
+
+	client.answer(first)
+
+	second := client.offer()
+	if _, ok := second.Tracks["a"]; !ok || sentTracks(second.Description.SDP) != 1 {
+		t.Fatalf("queued track missing from the next offer: tracks %v", second.Tracks)
+	}
+
+	// Два трека за время ожидания ответа уходят одним offer
+	addTestTrack(t, room, "b")
+	addTestTrack(t, room, "c")
+	client.expectNo("offer", 300*time.Millisecond)
+
+	client.answer(second)
+
+	third := client.offer()
+	if len(third.Tracks) != 3 || sentTracks(third.Description.SDP) != 3 {
+		t.Fatalf("expected one offer with all queued tracks, got tracks %v", third.Tracks)
+	}
+
+	client.answer(third)
+	client.expectNo("offer", 300*time.Millisecond)
+	waitFor(t, "stable signaling state", func() bool {
+		return serverSignalingState(peer) == webrtc.SignalingStateStable
+	})
+}
+
+// Клиент может начать согласование сам и получает answer
+func TestNegotiationClientOffer(t *testing.T) {
+	_, client, peer := joinTestPeer(t)
+
+	client.answer(client.offer())
+	waitFor(t, "stable signaling state", func() bool {
+		return serverSignalingState(peer) == webrtc.SignalingStateStable
+	})
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "mic", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+
+	client.sendOffer()
+	client.acceptAnswer()
+
+	if state := serverSignalingState(peer); state != webrtc.SignalingStateStable {
+		t.Fatalf("server signaling state %s after client offer", state)
+	}
+	if client.pc.SignalingState() != webrtc.SignalingStateStable {
+		t.Fatalf("client signaling state %s", client.pc.SignalingState())
+	}
+}
+
+// При glare сервер отклоняет offer клиента ошибкой glare и ждёт ответа на свой offer,
+// клиент (вежливая сторона) отказывается от своего offer, отвечает и повторяет его
+func TestNegotiationGlare(t *testing.T) {
+	room, client, peer := joinTestPeer(t)
+
+	client.answer(client.offer())
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "mic", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.pc.AddTrack(track); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offer сервера с новым треком и встречный offer клиента
+	addTestTrack(t, room, "a")
+	pending := client.offer()
+
+	// pion не умеет rollback, поэтому тестовый клиент применяет свой offer только после ответа:
+	// отказ от неприменённого offer равнозначен откату
+	colliding, err := client.pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.send("offer", colliding)
+
+	var sigErr signalingError
+	if err := json.Unmarshal(client.next("error").Payload, &sigErr); err != nil {
+		t.Fatal(err)
+	}
+	if sigErr.Code != errCodeGlare {
+		t.Fatalf("expected glare error, got %q", sigErr.Code)
+	}
+	if state := serverSignalingState(peer); state != webrtc.SignalingStateHaveLocalOffer {
+		t.Fatalf("server signaling state %s, expected its offer to stay pending", state)
+	}
+
+	client.answer(pending)
+	waitFor(t, "stable signaling state", func() bool {
+		return serverSignalingState(peer) == webrtc.SignalingStateStable
+	})
+
+	client.sendOffer()
+	client.acceptAnswer()
+
+	if state := serverSignalingState(peer); state != webrtc.SignalingStateStable {
+		t.Fatalf("server signaling state %s after the repeated offer", state)
+	}
+
+	if remote := peer.peerConnection.RemoteDescription(); remote == nil || !strings.Contains(remote.SDP, "a=msid:client mic") {
+		t.Fatal("repeated client offer does not carry the client track")
+	}
+}
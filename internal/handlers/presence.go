@@ -0,0 +1,137 @@
+package handlers
+
+import "sort"
+
+// Роли участников комнаты
+const (
+	roleParticipant = "participant"
+	roleIngest      = "ingest" // Публикация по WHIP (OBS, GStreamer)
+	roleViewer      = "viewer" // Просмотр по WHEP, в состав комнаты не входит
+	roleRelay       = "relay"  // Пересылка между узлами, в состав комнаты не входит
+)
+
+// participantInfo описывает участника комнаты для клиентов
+type participantInfo struct {
+	ID       string   `json:"id"`
+	Username string   `json:"username"`
+	Role     string   `json:"role"`
+	TrackIDs []string `json:"track_ids"`
+}
+
+// rosterSnapshot отправляется участнику при входе в комнату
+type rosterSnapshot struct {
+	Self         string            `json:"self"`
+	Participants []participantInfo `json:"participants"`
+}
+
+// participantInfo собирает описание участника по реестру треков комнаты.
+// Вызывается под ListLock комнаты.
+func (r *Room) participantInfo(p *peerConnectionState) participantInfo {
+	trackIDs := make([]string, 0)
+	for trackID, track := range r.Tracks {
+		if track.ParticipantID == p.id {
+			trackIDs = append(trackIDs, trackID)
+		}
+	}
+	sort.Strings(trackIDs)
+
+	return participantInfo{
+		ID:       p.id,
+		Username: p.username,
+		Role:     p.role,
+		TrackIDs: trackIDs,
+	}
+}
+
+// addPeer добавляет участника в комнату, отправляет ему состав комнаты
+// и оповещает остальных о новом участнике
+func (r *Room) addPeer(pcState *peerConnectionState) {
+	r.ListLock.Lock()
+	r.Peers = append(r.Peers, pcState)
+	joined := r.participantInfo(pcState)
+	r.ListLock.Unlock()
+
+	if err := r.sendRoster(pcState); err != nil {
+		pcState.log.Error("Failed to send roster", "err", err)
+	}
+
+	r.broadcastEvent("participant_joined", joined, pcState)
+}
+
+// sendRoster отправляет участнику текущий состав комнаты
+func (r *Room) sendRoster(pcState *peerConnectionState) error {
+	r.ListLock.RLock()
+	snapshot := rosterSnapshot{Self: pcState.id, Participants: make([]participantInfo, 0, len(r.Peers)+len(r.Publishers))}
+	for _, peer := range r.publishingPeers() {
+		snapshot.Participants = append(snapshot.Participants, r.participantInfo(peer))
+	}
+	r.ListLock.RUnlock()
+
+	return pcState.websocket.Load().WriteEvent("roster", snapshot)
+}
+
+// addPublisher регистрирует в комнате публикующую сессию без websocket (WHIP)
+func (r *Room) addPublisher(pcState *peerConnectionState) {
+	r.ListLock.Lock()
+	r.Publishers[pcState.id] = pcState
+	joined := r.participantInfo(pcState)
+	r.ListLock.Unlock()
+
+	r.broadcastEvent("participant_joined", joined, nil)
+}
+
+// removePublisher убирает сессию WHIP из комнаты. Повторный вызов ничего не делает.
+func (r *Room) removePublisher(pcState *peerConnectionState) {
+	r.ListLock.Lock()
+	if _, ok := r.Publishers[pcState.id]; !ok {
+		r.ListLock.Unlock()
+		return
+	}
+	delete(r.Publishers, pcState.id)
+	left := r.participantInfo(pcState)
+	r.ListLock.Unlock()
+
+	r.broadcastEvent("participant_left", left, nil)
+}
+
+// publishingPeers возвращает всех участников, которые могут публиковать треки:
+// websocket-участников и сессии WHIP. Вызывается под ListLock комнаты.
+func (r *Room) publishingPeers() []*peerConnectionState {
+	peers := make([]*peerConnectionState, 0, len(r.Peers)+len(r.Publishers))
+	peers = append(peers, r.Peers...)
+	for _, publisher := range r.Publishers {
+		peers = append(peers, publisher)
+	}
+
+	return peers
+}
+
+// notifyParticipantUpdated оповещает комнату об изменении участника
+func (r *Room) notifyParticipantUpdated(pcState *peerConnectionState) {
+	// Соединения пересылки не участники, их треки описаны владельцами на исходном узле
+	if pcState.role == roleRelay {
+		return
+	}
+
+	r.ListLock.RLock()
+	updated := r.participantInfo(pcState)
+	r.ListLock.RUnlock()
+
+	r.broadcastEvent("participant_updated", updated, nil)
+}
+
+// broadcastEvent рассылает событие всем участникам комнаты, кроме except.
+// Нельзя вызывать под ListLock.
+func (r *Room) broadcastEvent(event string, payload interface{}, except *peerConnectionState) {
+	r.ListLock.RLock()
+	defer r.ListLock.RUnlock()
+
+	for _, peer := range r.Peers {
+		if peer == except {
+			continue
+		}
+		if err := peer.websocket.Load().WriteEvent(event, payload); err != nil {
+			peer.log.Error("Failed to send event", "event", event, "err", err)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Восстановление соединения: после PeerConnectionStateFailed сервер перезапускает ICE
+// с экспоненциальной задержкой и закрывает участника только после maxICERestartAttempts неудач
+const (
+	maxICERestartAttempts = 3
+	iceRestartBaseDelay   = time.Second
+	iceRestartTimeout     = 15 * time.Second // Сколько ждать восстановления после одной попытки
+)
+
+// Состояния события connection_quality
+const (
+	connectionQualityGood       = "good"
+	connectionQualityUnstable   = "unstable"   // ICE disconnected, pion ещё может восстановиться сам
+	connectionQualityRecovering = "recovering" // Идёт перезапуск ICE
+	connectionQualityLost       = "lost"       // Попытки исчерпаны, соединение закрывается
+)
+
+// connectionQuality отправляется участнику при изменении качества его соединения
+type connectionQuality struct {
+	State       string `json:"state"`
+	Attempt     int    `json:"attempt,omitempty"`
+	MaxAttempts int    `json:"max_attempts,omitempty"`
+}
+
+// ICERestarts возвращает счётчики перезапусков ICE узла: attempts, succeeded и failed
+func (s *Server) ICERestarts() expvar.Var {
+	return &s.iceRestarts
+}
+
+// recoveryState хранит ход восстановления соединения одного участника
+type recoveryState struct {
+	sync.Mutex
+	attempt int
+	timer   *time.Timer // Отложенная попытка или ожидание её результата
+}
+
+// handleConnectionState ведёт восстановление websocket-участника по изменениям состояния PeerConnection
+func (r *Room) handleConnectionState(pcState *peerConnectionState, state webrtc.PeerConnectionState) {
+	switch state {
+	case webrtc.PeerConnectionStateConnected:
+		pcState.recovery.Lock()
+		recovered := pcState.recovery.attempt > 0
+		pcState.recovery.attempt = 0
+		if pcState.recovery.timer != nil {
+			pcState.recovery.timer.Stop()
+			pcState.recovery.timer = nil
+		}
+		pcState.recovery.Unlock()
+
+		if recovered {
+			r.server.iceRestarts.Add("succeeded", 1)
+			pcState.log.Info("Connection recovered by ICE restart")
+		}
+		r.sendConnectionQuality(pcState, connectionQuality{State: connectionQualityGood})
+	case webrtc.PeerConnectionStateDisconnected:
+		r.sendConnectionQuality(pcState, connectionQuality{State: connectionQualityUnstable})
+	case webrtc.PeerConnectionStateFailed:
+		r.recoverConnection(pcState)
+	case webrtc.PeerConnectionStateClosed:
+		pcState.recovery.Lock()
+		if pcState.recovery.timer != nil {
+			pcState.recovery.timer.Stop()
+			pcState.recovery.timer = nil
+		}
+		pcState.recovery.Unlock()
+	default:
+	}
+}
+
+// recoverConnection планирует следующую попытку перезапуска ICE или закрывает соединение,
+// если попытки исчерпаны. Пока попытка ждёт результата, повторные вызовы ничего не делают.
+func (r *Room) recoverConnection(pcState *peerConnectionState) {
+	pcState.recovery.Lock()
+	defer pcState.recovery.Unlock()
+
+	if pcState.recovery.timer != nil || pcState.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		return
+	}
+
+	if pcState.recovery.attempt >= maxICERestartAttempts {
+		r.server.iceRestarts.Add("failed", 1)
+		pcState.log.Info("Connection was not recovered by ICE restarts", "attempts", pcState.recovery.attempt)
+
+		r.sendConnectionQuality(pcState, connectionQuality{State: connectionQualityLost})
+		go func() {
+			if err := pcState.peerConnection.Close(); err != nil {
+				pcState.log.Error("Failed to close PeerConnection", "err", err)
+			}
+		}()
+		return
+	}
+
+	pcState.recovery.attempt++
+	attempt := pcState.recovery.attempt
+	delay := iceRestartBaseDelay << (attempt - 1)
+
+	r.sendConnectionQuality(pcState, connectionQuality{
+		State:       connectionQualityRecovering,
+		Attempt:     attempt,
+		MaxAttempts: maxICERestartAttempts,
+	})
+
+	pcState.recovery.timer = time.AfterFunc(delay, func() {
+		r.server.iceRestarts.Add("attempts", 1)
+		pcState.log.Info("ICE restart", "attempt", attempt)
+
+		r.restartICE(pcState)
+
+		// Не восстановилось за iceRestartTimeout — следующая попытка
+		pcState.recovery.Lock()
+		pcState.recovery.timer = time.AfterFunc(iceRestartTimeout, func() {
+			pcState.recovery.Lock()
+			pcState.recovery.timer = nil
+			pcState.recovery.Unlock()
+
+			if pcState.peerConnection.ConnectionState() != webrtc.PeerConnectionStateConnected {
+				r.recoverConnection(pcState)
+			}
+		})
+		pcState.recovery.Unlock()
+	})
+}
+
+func (r *Room) sendConnectionQuality(pcState *peerConnectionState, quality connectionQuality) {
+	if err := pcState.websocket.Load().WriteEvent("connection_quality", quality); err != nil {
+		pcState.log.Error("Failed to send connection quality", "err", err)
+	}
+}
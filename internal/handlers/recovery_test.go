@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expvar"
+	"strings"
+	"testing"
+
+	"github.com/pion/webrtc/v4"
+)
+
+func iceUfrag(sdp string) string {
+	_, rest, _ := strings.Cut(sdp, "a=ice-ufrag:")
+	ufrag, _, _ := strings.Cut(rest, "\r\n")
+
+	return ufrag
+}
+
+func iceRestartCount(s *Server, key string) int64 {
+	counter, ok := s.ICERestarts().(*expvar.Map).Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+
+	return counter.Value()
+}
+
+// После Failed сервер перезапускает ICE и считает попытку только в своих счётчиках
+func TestRecoverConnectionICERestart(t *testing.T) {
+	room, client, peer := joinTestPeer(t)
+	other := newTestServer(t)
+
+	first := client.offer()
+	client.answer(first)
+
+	room.handleConnectionState(peer, webrtc.PeerConnectionStateFailed)
+
+	var quality connectionQuality
+	if err := json.Unmarshal(client.next("connection_quality").Payload, &quality); err != nil {
+		t.Fatal(err)
+	}
+	if quality.State != connectionQualityRecovering || quality.Attempt != 1 {
+		t.Fatalf("connection quality %+v", quality)
+	}
+
+	restart := client.offer()
+	if iceUfrag(restart.Description.SDP) == iceUfrag(first.Description.SDP) {
+		t.Fatal("ICE restart offer keeps the previous ICE credentials")
+	}
+
+	if count := iceRestartCount(room.server, "attempts"); count != 1 {
+		t.Fatalf("%d ICE restart attempts counted", count)
+	}
+	if count := iceRestartCount(other, "attempts"); count != 0 {
+		t.Fatalf("ICE restarts leaked into another server: %d", count)
+	}
+
+	peer.peerConnection.Close()
+}
@@ -0,0 +1,510 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+)
+
+// Каскадная пересылка медиа между узлами: комната узла A отправляет свои треки в зеркальную комнату узла B
+// по отдельному PeerConnection, и участники обоих узлов видят друг друга.
+// Каждое соединение однонаправленное: offer всегда делает отправляющий узел, поэтому встречных offer не бывает.
+// Первый offer передаётся запросом POST /relay/{room}, последующие — по data channel самого соединения.
+// Без RELAY_SECRET пересылка выключена.
+const (
+	relayChannelLabel = "relay"          // Data channel для сигнализации между узлами
+	relayDialTimeout  = 30 * time.Second // Предельное время установки пересылки
+)
+
+var (
+	errRelayDisabled = errors.New("media relay is disabled")
+	errRelayNotReady = errors.New("relay channel is not open")
+
+	errMirrorOwnedElsewhere   = errors.New("room belongs to another cluster node")
+	errMirrorPasswordMismatch = errors.New("room exists with a different password")
+)
+
+// relayRequest — запрос пересылки к удалённому узлу
+type relayRequest struct {
+	Password        string                    `json:"password"`
+	MaxScreenShares int                       `json:"max_screen_shares,omitempty"`
+	Offer           webrtc.SessionDescription `json:"offer"`
+	Origin          string                    `json:"origin,omitempty"`      // Адрес узла-источника для встречной пересылки
+	OriginRoom      string                    `json:"origin_room,omitempty"` // Комната узла-источника, в которую пересылать встречно
+}
+
+type relayResponse struct {
+	Answer webrtc.SessionDescription `json:"answer"`
+}
+
+// StartRelayRequest — запрос оператора на пересылку комнаты этого узла на другой узел
+type StartRelayRequest struct {
+	Room       string `json:"room"`
+	Target     string `json:"target"`                // Базовый URL удалённого узла
+	TargetRoom string `json:"target_room,omitempty"` // Зеркальная комната, по умолчанию с тем же именем
+	Mutual     bool   `json:"mutual,omitempty"`      // Попросить удалённый узел пересылать свои треки в ответ
+}
+
+// relayLink — сигнализация соединения пересылки через data channel
+type relayLink struct {
+	sync.Mutex
+	channel *webrtc.DataChannel
+	tracks  map[string]trackInfo // Метаданные треков из последнего offer узла-источника
+}
+
+// setChannel запоминает data channel, открытый удалённым узлом
+func (l *relayLink) setChannel(channel *webrtc.DataChannel) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.channel = channel
+}
+
+// ready сообщает, можно ли отправлять сообщения по data channel
+func (l *relayLink) ready() bool {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.channel != nil && l.channel.ReadyState() == webrtc.DataChannelStateOpen
+}
+
+func (l *relayLink) send(messageType string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	message, err := json.Marshal(signalingMessage{Type: messageType, Payload: data})
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	channel := l.channel
+	l.Unlock()
+
+	if channel == nil {
+		return errRelayNotReady
+	}
+
+	return channel.SendText(string(message))
+}
+
+// setTracks запоминает метаданные треков, пришедшие вместе с offer
+func (l *relayLink) setTracks(tracks map[string]trackInfo) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.tracks = tracks
+}
+
+// describe переносит на пересланный трек владельца, источник и mute с исходного узла
+// и помечает трек, чтобы он не уходил обратно по пересылке
+func (l *relayLink) describe(track *trackInfo) {
+	l.Lock()
+	defer l.Unlock()
+
+	track.relayed = true
+
+	origin, ok := l.tracks[track.TrackID]
+	if !ok {
+		return
+	}
+
+	track.ParticipantID = origin.ParticipantID
+	track.Username = origin.Username
+	track.Source = origin.Source
+	track.Muted = origin.Muted
+}
+
+// RelayTo начинает пересылку треков комнаты в комнату targetRoom узла target.
+// Если задан origin, удалённый узел встречно пересылает свои треки в эту комнату узла origin.
+func (r *Room) RelayTo(ctx context.Context, target, targetRoom, origin string) error {
+	if r.server.cfg.Relay.Secret == "" {
+		return errRelayDisabled
+	}
+
+	r.server.roomsLock.RLock()
+	password := r.server.passwords[r.Name]
+	r.server.roomsLock.RUnlock()
+
+	peerConnection, err := r.server.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return err
+	}
+
+	pcState := &peerConnectionState{
+		id:             uuid.New().String(),
+		peerConnection: peerConnection,
+		username:       target,
+		role:           roleRelay,
+		relay:          &relayLink{},
+	}
+	pcState.log = r.log.With("peer", pcState.id, "relay_target", target)
+
+	channel, err := peerConnection.CreateDataChannel(relayChannelLabel, nil)
+	if err != nil {
+		peerConnection.Close()
+		return err
+	}
+	pcState.relay.setChannel(channel)
+
+	// Треки добавляются после открытия канала: offer с ними уйдёт уже по нему
+	channel.OnOpen(func() {
+		r.renegotiate(pcState)
+	})
+	channel.OnMessage(func(msg webrtc.DataChannelMessage) {
+		r.handleRelayMessage(pcState, msg.Data)
+	})
+
+	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		switch p {
+		case webrtc.PeerConnectionStateFailed:
+			pcState.log.Warn("Relay failed")
+			peerConnection.Close()
+		case webrtc.PeerConnectionStateClosed:
+			r.signalPeerConnections()
+		}
+	})
+
+	offer, err := peerConnection.CreateOffer(nil)
+	if err != nil {
+		peerConnection.Close()
+		return err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(offer); err != nil {
+		peerConnection.Close()
+		return err
+	}
+	<-gatherComplete
+
+	request := relayRequest{
+		Password:        password,
+		MaxScreenShares: r.MaxScreenShares,
+		Offer:           *peerConnection.LocalDescription(),
+	}
+	if origin != "" {
+		request.Origin = origin
+		request.OriginRoom = r.Name
+	}
+
+	answer, err := r.server.requestRelay(ctx, target, targetRoom, request)
+	if err != nil {
+		peerConnection.Close()
+		return err
+	}
+
+	r.ListLock.Lock()
+	r.RelaySinks[pcState.id] = pcState
+	r.ListLock.Unlock()
+
+	if err = peerConnection.SetRemoteDescription(answer); err != nil {
+		peerConnection.Close()
+		return err
+	}
+
+	pcState.log.Info("Relaying room", "target_room", targetRoom)
+
+	return nil
+}
+
+// requestRelay передаёт offer пересылки удалённому узлу и возвращает его answer
+func (s *Server) requestRelay(ctx context.Context, target, targetRoom string, request relayRequest) (webrtc.SessionDescription, error) {
+	ctx, cancel := context.WithTimeout(ctx, relayDialTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	url := strings.TrimSuffix(target, "/") + "/relay/" + targetRoom
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Authorization", bearerAuthSchemePrefix+s.cfg.Relay.Secret)
+
+	response, err := http.DefaultClient.Do(httpRequest)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return webrtc.SessionDescription{}, fmt.Errorf("relay rejected by %s: %s", target, response.Status)
+	}
+
+	var relay relayResponse
+	if err = json.NewDecoder(response.Body).Decode(&relay); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+
+	return relay.Answer, nil
+}
+
+// handleRelayMessage обрабатывает сигнализацию соединения пересылки:
+// узел-источник присылает offer, узел-получатель отвечает answer
+func (r *Room) handleRelayMessage(pcState *peerConnectionState, data []byte) {
+	var message signalingMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		pcState.log.Error("Failed to unmarshal relay message", "err", err)
+		return
+	}
+
+	switch message.Type {
+	case "offer":
+		var offer offerPayload
+		if err := json.Unmarshal(message.Payload, &offer); err != nil {
+			pcState.log.Error("Failed to unmarshal relay offer", "err", err)
+			return
+		}
+
+		// Метаданные должны быть на месте до OnTrack новых треков
+		pcState.relay.setTracks(offer.Tracks)
+		if err := r.acceptOffer(pcState, offer.Description); err != nil {
+			pcState.log.Error("Failed to accept relay offer", "err", err)
+		}
+	case "answer":
+		var answer webrtc.SessionDescription
+		if err := json.Unmarshal(message.Payload, &answer); err != nil {
+			pcState.log.Error("Failed to unmarshal relay answer", "err", err)
+			return
+		}
+
+		if err := r.acceptAnswer(pcState, answer); err != nil {
+			pcState.log.Error("Failed to accept relay answer", "err", err)
+		}
+	default:
+		pcState.log.Warn("Unknown relay message type", "type", message.Type)
+	}
+}
+
+// RelayHandler принимает пересылку комнаты с другого узла: POST /relay/{room}.
+// Зеркальная комната создаётся с паролем исходной, если её ещё нет; существующая комната с другим паролем — 409.
+func (s *Server) RelayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.rejectDraining(w) {
+		return
+	}
+
+	if !s.authorizeRelay(w, r) {
+		return
+	}
+
+	var req relayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Offer.Type != webrtc.SDPTypeOffer {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	room, err := s.mirrorRoom(r.Context(), r.PathValue("room"), req.Password, req.MaxScreenShares)
+	switch {
+	case errors.Is(err, errMirrorOwnedElsewhere), errors.Is(err, errMirrorPasswordMismatch):
+		http.Error(w, "Room already exists", http.StatusConflict)
+		return
+	case err != nil:
+		s.requestLog(r).Error("Failed to claim room", "room", r.PathValue("room"), "err", err)
+		http.Error(w, "Cluster unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	peerConnection, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		room.requestLog(r).Error("Failed to create PeerConnection", "err", err)
+		http.Error(w, "Failed to create PeerConnection", http.StatusInternalServerError)
+		return
+	}
+
+	pcState := &peerConnectionState{
+		id:             uuid.New().String(),
+		peerConnection: peerConnection,
+		username:       req.Origin,
+		role:           roleRelay,
+		relay:          &relayLink{},
+	}
+	pcState.log = room.requestLog(r).With("peer", pcState.id, "relay_origin", req.Origin)
+
+	peerConnection.OnDataChannel(func(channel *webrtc.DataChannel) {
+		if channel.Label() != relayChannelLabel {
+			return
+		}
+		pcState.relay.setChannel(channel)
+		channel.OnMessage(func(msg webrtc.DataChannelMessage) {
+			room.handleRelayMessage(pcState, msg.Data)
+		})
+	})
+
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		room.forwardTrack(pcState, t, receiver)
+	})
+
+	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		switch p {
+		case webrtc.PeerConnectionStateFailed:
+			peerConnection.Close()
+		case webrtc.PeerConnectionStateClosed:
+			room.ListLock.Lock()
+			delete(room.RelaySources, pcState.id)
+			room.ListLock.Unlock()
+		}
+	})
+
+	room.ListLock.Lock()
+	room.RelaySources[pcState.id] = pcState
+	room.ListLock.Unlock()
+
+	answer, err := answerOffer(peerConnection, req.Offer.SDP)
+	if err != nil {
+		pcState.log.Error("Failed to answer relay offer", "err", err)
+		peerConnection.Close()
+		http.Error(w, "Invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(relayResponse{
+		Answer: webrtc.SessionDescription{Type: webrtc.SDPTypeAnswer, SDP: answer},
+	})
+
+	pcState.log.Info("Accepted relay")
+
+	// Встречная пересылка: треки этого узла уходят в комнату узла-источника
+	if req.Origin != "" && req.OriginRoom != "" {
+		go func() {
+			if err := room.RelayTo(context.Background(), req.Origin, req.OriginRoom, ""); err != nil {
+				pcState.log.Error("Failed to relay room back", "err", err)
+			}
+		}()
+	}
+}
+
+// StartRelayHandler начинает пересылку комнаты этого узла на другой узел: POST /api/relay
+func (s *Server) StartRelayHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.rejectDraining(w) {
+		return
+	}
+
+	if !s.authorizeRelay(w, r) {
+		return
+	}
+
+	var req StartRelayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Room == "" || req.Target == "" {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.TargetRoom == "" {
+		req.TargetRoom = req.Room
+	}
+
+	s.roomsLock.RLock()
+	room, exists := s.rooms[req.Room]
+	s.roomsLock.RUnlock()
+
+	if !exists {
+		http.Error(w, "Room does not exist", http.StatusNotFound)
+		return
+	}
+
+	origin := ""
+	if req.Mutual {
+		origin = s.relayOrigin(r)
+	}
+
+	if err := room.RelayTo(r.Context(), req.Target, req.TargetRoom, origin); err != nil {
+		room.requestLog(r).Error("Failed to start relay", "target", req.Target, "err", err)
+		http.Error(w, "Failed to start relay", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorizeRelay проверяет общий секрет пересылки в заголовке Authorization
+func (s *Server) authorizeRelay(w http.ResponseWriter, r *http.Request) bool {
+	if s.cfg.Relay.Secret == "" {
+		http.NotFound(w, r)
+		return false
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), bearerAuthSchemePrefix)
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Relay.Secret)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="relay"`)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// relayOrigin возвращает адрес этого узла для встречной пересылки
+func (s *Server) relayOrigin(r *http.Request) string {
+	if s.cluster != nil {
+		return s.cluster.Self.Address
+	}
+
+	return s.baseURL(r)
+}
+
+// mirrorRoom возвращает комнату для принятой пересылки, создавая её при необходимости.
+// Существующая комната подходит, только если пароль совпадает с паролем исходной; в кластере новая комната
+// закрепляется за узлом, как при создании через API.
+func (s *Server) mirrorRoom(ctx context.Context, name, password string, maxScreenShares int) (*Room, error) {
+	s.roomsLock.RLock()
+	_, exists := s.rooms[name]
+	s.roomsLock.RUnlock()
+
+	if !exists && s.cluster != nil {
+		_, local, err := s.cluster.ClaimRoom(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		if !local {
+			return nil, errMirrorOwnedElsewhere
+		}
+	}
+
+	s.roomsLock.Lock()
+	defer s.roomsLock.Unlock()
+
+	if room, exists := s.rooms[name]; exists {
+		if subtle.ConstantTimeCompare([]byte(s.passwords[name]), []byte(password)) != 1 {
+			return nil, errMirrorPasswordMismatch
+		}
+		return room, nil
+	}
+
+	if maxScreenShares == 0 {
+		maxScreenShares = s.cfg.Limits.MaxScreenShares
+	}
+
+	room := s.newRoom(name, maxScreenShares)
+	s.rooms[name] = room
+	s.passwords[name] = password
+
+	return room, nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"webrtc-app/internal/cluster"
+	"webrtc-app/internal/config"
+
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v4"
+)
+
+const testRelaySecret = "relay-secret"
+
+// publishTestMedia отправляет в трек пакеты VP8, пока не закончится тест:
+// OnTrack на принимающем узле срабатывает только с первым пакетом
+func publishTestMedia(t *testing.T, track *webrtc.TrackLocalStaticRTP) {
+	stop := make(chan struct{})
+	t.Cleanup(func() { close(stop) })
+
+	go func() {
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		packet := &rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 96}, Payload: []byte{0x10, 0x00, 0x00, 0x00}}
+		for {
+			select {
+			case <-ticker.C:
+				packet.SequenceNumber++
+				packet.Timestamp += 1800
+				if err := track.WriteRTP(packet); err != nil {
+					return
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+func relayedTrack(room *Room, id string) (trackInfo, bool) {
+	room.ListLock.RLock()
+	defer room.ListLock.RUnlock()
+
+	info, ok := room.Tracks[id]
+	if !ok {
+		return trackInfo{}, false
+	}
+
+	return *info, true
+}
+
+// Трек комнаты узла A доходит до зеркальной комнаты узла B, а при встречной пересылке не возвращается обратно
+func TestRelayMutual(t *testing.T) {
+	withRelay := func(cfg *config.Config) {
+		cfg.Relay.Secret = testRelaySecret
+	}
+
+	origin := newTestServer(t, withRelay)
+	originTS := serveTestServer(t, origin)
+	mirror := newTestServer(t, withRelay)
+	mirrorTS := serveTestServer(t, mirror)
+
+	room := createTestRoom(t, origin, originTS.URL, "relayed")
+	publishTestMedia(t, addTestTrack(t, room, "camera"))
+
+	request, err := http.NewRequest(http.MethodPost, originTS.URL+"/api/relay",
+		strings.NewReader(`{"room":"relayed","target":"`+mirrorTS.URL+`","mutual":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testRelaySecret)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusNoContent {
+		t.Fatalf("start relay: %s", response.Status)
+	}
+
+	var mirrorRoom *Room
+	waitFor(t, "mirror room", func() bool {
+		mirrorRoom = mirror.testRoom("relayed")
+		return mirrorRoom != nil
+	})
+	waitFor(t, "relayed track in the mirror room", func() bool {
+		info, ok := relayedTrack(mirrorRoom, "camera")
+		return ok && info.relayed
+	})
+
+	// Встречная пересылка доставляет собственный трек зеркальной комнаты, но не пересланный
+	publishTestMedia(t, addTestTrack(t, mirrorRoom, "mirror-camera"))
+	waitFor(t, "mirror track back in the origin room", func() bool {
+		info, ok := relayedTrack(room, "mirror-camera")
+		return ok && info.relayed
+	})
+
+	mirrorRoom.ListLock.RLock()
+	defer mirrorRoom.ListLock.RUnlock()
+
+	if len(mirrorRoom.RelaySinks) != 1 {
+		t.Fatalf("mirror room has %d relay sinks", len(mirrorRoom.RelaySinks))
+	}
+	for _, sink := range mirrorRoom.RelaySinks {
+		for _, sender := range sink.peerConnection.GetSenders() {
+			if sender.Track() != nil && sender.Track().ID() == "camera" {
+				t.Fatal("relayed track is sent back to its origin")
+			}
+		}
+	}
+
+	if info, ok := relayedTrack(room, "camera"); !ok || info.relayed {
+		t.Fatalf("origin track replaced by its relayed copy: %+v", info)
+	}
+}
+
+func relayRequestStatus(t *testing.T, baseURL, room, password string) int {
+	t.Helper()
+
+	request, err := http.NewRequest(http.MethodPost, baseURL+"/relay/"+room,
+		strings.NewReader(`{"password":"`+password+`","offer":{"type":"offer","sdp":"v=0"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer "+testRelaySecret)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	return response.StatusCode
+}
+
+// Пересылка не присоединяется к чужой комнате с тем же именем и не занимает комнату другого узла кластера
+func TestRelayMirrorRoomConflicts(t *testing.T) {
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Relay.Secret = testRelaySecret
+	})
+	ts := serveTestServer(t, s)
+	createTestRoom(t, s, ts.URL, "taken")
+
+	if status := relayRequestStatus(t, ts.URL, "taken", "other"); status != http.StatusConflict {
+		t.Fatalf("relay into a room with another password: %d", status)
+	}
+
+	store := cluster.NewMemoryStore()
+	owner, ownerTS := newTestClusterNode(t, store, "a")
+	other, otherTS := newTestClusterNode(t, store, "b")
+	other.cfg.Relay.Secret = testRelaySecret
+	createTestRoom(t, owner, ownerTS.URL, "clustered")
+
+	if status := relayRequestStatus(t, otherTS.URL, "clustered", "pw"); status != http.StatusConflict {
+		t.Fatalf("relay into a room owned by another node: %d", status)
+	}
+	if other.testRoom("clustered") != nil {
+		t.Fatal("relay created a copy of another node's room")
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	// Битрейты (бит/с), которые сервер запрашивает у публикующих через REMB:
+	// демонстрация экрана всегда идёт в высоком качестве, камеры на время демонстрации ужимаются
+	presenterBitrate             = 2_500_000
+	cameraBitrateWhilePresenting = 500_000
+)
+
+var (
+	errScreenShareLimit  = errors.New("screen share limit reached")
+	errScreenShareActive = errors.New("screen share already started")
+)
+
+// startScreenShare добавляет участнику отдельный RecvOnly трансивер под демонстрацию экрана
+// и запускает повторное согласование. Клиент прикрепляет трек экрана к новому m-line в ответе.
+func (r *Room) startScreenShare(pcState *peerConnectionState) error {
+	r.ListLock.Lock()
+	if pcState.screenShare != nil {
+		r.ListLock.Unlock()
+		return errScreenShareActive
+	}
+
+	if r.activeScreenShares() >= r.MaxScreenShares {
+		r.ListLock.Unlock()
+		return errScreenShareLimit
+	}
+
+	transceiver, err := pcState.peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionRecvonly,
+	})
+	if err != nil {
+		r.ListLock.Unlock()
+		return err
+	}
+	pcState.screenShare = transceiver
+	r.ListLock.Unlock()
+
+	r.signalPeerConnections()
+	return nil
+}
+
+// stopScreenShare останавливает трансивер демонстрации экрана участника.
+// Трек удаляется из комнаты, когда завершится его цикл чтения в OnTrack.
+func (r *Room) stopScreenShare(pcState *peerConnectionState) error {
+	r.ListLock.Lock()
+	transceiver := pcState.screenShare
+	pcState.screenShare = nil
+	r.ListLock.Unlock()
+
+	if transceiver == nil {
+		return nil
+	}
+
+	if err := transceiver.Stop(); err != nil {
+		return err
+	}
+
+	r.signalPeerConnections()
+	return nil
+}
+
+// trackSource определяет источник входящего трека участника
+func (r *Room) trackSource(pcState *peerConnectionState, t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) string {
+	r.ListLock.RLock()
+	defer r.ListLock.RUnlock()
+
+	if pcState.screenShare != nil && pcState.screenShare.Receiver() == receiver {
+		return trackSourceScreen
+	}
+
+	return sourceForKind(t.Kind())
+}
+
+// presenterPriority возвращает REMB для входящего видеотрека согласно правилу приоритета докладчика
+// или nil, если ограничивать трек не нужно. Вызывается под ListLock комнаты.
+func (r *Room) presenterPriority(track *webrtc.TrackRemote, presenting bool) rtcp.Packet {
+	info, ok := r.Tracks[track.ID()]
+	if !ok {
+		return nil
+	}
+
+	switch {
+	case info.Source == trackSourceScreen:
+		return &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: presenterBitrate, SSRCs: []uint32{uint32(track.SSRC())}}
+	case presenting && info.Source == trackSourceCamera:
+		return &rtcp.ReceiverEstimatedMaximumBitrate{Bitrate: cameraBitrateWhilePresenting, SSRCs: []uint32{uint32(track.SSRC())}}
+	default:
+		return nil
+	}
+}
+
+// activeScreenShares считает демонстрации экрана в комнате: треки экрана от любых источников, включая WHIP
+// и пересылку с других узлов, и начатые участниками демонстрации, трек которых ещё не пришёл.
+// Вызывается под ListLock комнаты.
+func (r *Room) activeScreenShares() int {
+	active := 0
+	sharing := make(map[string]bool)
+	for _, track := range r.Tracks {
+		if track.Source == trackSourceScreen {
+			active++
+			sharing[track.ParticipantID] = true
+		}
+	}
+	for _, peer := range r.Peers {
+		if peer.screenShare != nil && !sharing[peer.id] {
+			active++
+		}
+	}
+
+	return active
+}
+
+// presenting сообщает, идёт ли в комнате демонстрация экрана. Вызывается под ListLock комнаты.
+func (r *Room) presenting() bool {
+	for _, track := range r.Tracks {
+		if track.Source == trackSourceScreen {
+			return true
+		}
+	}
+
+	return false
+}
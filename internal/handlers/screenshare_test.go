@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+)
+
+// Лимит демонстраций учитывает треки экрана, пришедшие не от websocket-участников
+func TestScreenShareLimitCountsRelayedScreens(t *testing.T) {
+	room, client, peer := joinTestPeer(t)
+	client.answer(client.offer())
+
+	room.ListLock.Lock()
+	room.Tracks["remote-screen"] = &trackInfo{TrackID: "remote-screen", ParticipantID: "remote", Kind: "video", Source: trackSourceScreen, paused: &atomic.Bool{}, relayed: true}
+	room.ListLock.Unlock()
+
+	if err := room.startScreenShare(peer); !errors.Is(err, errScreenShareLimit) {
+		t.Fatalf("screen share past the limit: %v", err)
+	}
+
+	room.ListLock.Lock()
+	delete(room.Tracks, "remote-screen")
+	room.ListLock.Unlock()
+
+	if err := room.startScreenShare(peer); err != nil {
+		t.Fatalf("screen share within the limit: %v", err)
+	}
+
+	peer.peerConnection.Close()
+}
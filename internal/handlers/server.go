@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"expvar"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"webrtc-app/internal/cluster"
+	"webrtc-app/internal/config"
+	"webrtc-app/internal/logger"
+
+	"github.com/gorilla/websocket"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/pion/logging"
+	"github.com/pion/webrtc/v4"
+)
+
+// Server — узел SFU: реестр комнат, настройки, кластер, хранилище и логгер.
+// Обработчики HTTP — его методы, в одном процессе может работать несколько узлов.
+type Server struct {
+	cfg           config.Config
+	log           *slog.Logger
+	loggerFactory logging.LoggerFactory // Логи pion в тот же slog
+	api           *webrtc.API           // API для всех PeerConnection узла
+	iceListeners  []io.Closer           // Мультиплексоры ICE UDP и TCP, закрываются в Close
+	upgrader      websocket.Upgrader
+
+	trustedProxies []netip.Prefix // Прокси, которым доверяется X-Forwarded-Proto
+	origins        originPolicy   // Источники, которым разрешены API и websocket
+
+	rooms     map[string]*Room
+	passwords map[string]string // Хранилище паролей комнат
+	roomsLock sync.RWMutex
+
+	cluster  *cluster.Cluster // nil в одиночном режиме
+	db       *pgxpool.Pool    // nil без Postgres
+	turnURLs []string         // Адреса встроенного TURN-сервера для клиентов, заполняются при запуске
+	draining atomic.Bool
+
+	iceRestarts expvar.Map // Счётчики перезапусков ICE, cmd/main.go публикует их в /debug/vars
+	startedAt   time.Time
+}
+
+// NewServer создаёт узел по конфигурации: логгер по LOG_LEVEL и LOG_FORMAT и API WebRTC.
+// node и db необязательны: без node узел работает один, без db не проверяется Postgres.
+func NewServer(cfg *config.Config, node *cluster.Cluster, db *pgxpool.Pool) (*Server, error) {
+	log := logger.New(cfg.Log)
+
+	trustedProxies, err := parseTrustedProxies(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:            *cfg,
+		log:            log,
+		loggerFactory:  logger.NewLoggerFactory(log),
+		trustedProxies: trustedProxies,
+		origins:        newOriginPolicy(cfg.CORS.AllowedOrigins),
+		rooms:          make(map[string]*Room),
+		passwords:      make(map[string]string),
+		cluster:        node,
+		db:             db,
+		startedAt:      time.Now(),
+	}
+
+	s.upgrader = websocket.Upgrader{
+		CheckOrigin:  s.originAllowed,
+		Subprotocols: []string{signalingProtocolV1},
+	}
+
+	if err := s.configureWebRTC(cfg.ICE); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Close освобождает порты мультиплексоров ICE. Вызывается после Drain, когда PeerConnection узла закрыты.
+func (s *Server) Close() error {
+	var errs []error
+	for _, listener := range s.iceListeners {
+		errs = append(errs, listener.Close())
+	}
+	s.iceListeners = nil
+
+	return errors.Join(errs...)
+}
+
+// DispatchKeyFrames запрашивает ключевые кадры у публикующих во всех комнатах узла
+func (s *Server) DispatchKeyFrames() {
+	for _, room := range s.roomList() {
+		room.DispatchKeyFrame()
+	}
+}
+
+// requestLog возвращает логгер запроса с его идентификатором
+func (s *Server) requestLog(r *http.Request) *slog.Logger {
+	return withRequestID(s.log, r)
+}
+
+// requestLog возвращает логгер комнаты с идентификатором запроса
+func (r *Room) requestLog(req *http.Request) *slog.Logger {
+	return withRequestID(r.log, req)
+}
+
+func withRequestID(log *slog.Logger, r *http.Request) *slog.Logger {
+	if id := logger.RequestIDFrom(r.Context()); id != "" {
+		return log.With("request_id", id)
+	}
+
+	return log
+}
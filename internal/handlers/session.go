@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// Время, в течение которого отключившийся участник может вернуться в свою сессию
+// с сохранением PeerConnection и опубликованных треков
+const resumeGracePeriod = 30 * time.Second
+
+var errSessionExpired = errors.New("session expired or unknown")
+
+// sessionInfo отправляется участнику при входе событием session.
+// Token передаётся параметром resume при переподключении websocket.
+type sessionInfo struct {
+	ID            string `json:"id"`
+	Token         string `json:"token"`
+	ResumeTimeout int    `json:"resume_timeout"` // Секунды
+}
+
+func newResumeToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// sendSession сообщает участнику идентификатор и токен возобновления его сессии
+func (p *peerConnectionState) sendSession() error {
+	return p.websocket.Load().WriteEvent("session", sessionInfo{
+		ID:            p.id,
+		Token:         p.resumeToken,
+		ResumeTimeout: int(resumeGracePeriod / time.Second),
+	})
+}
+
+// resumeSession подключает новый websocket к сохранённой сессии участника.
+// lastChat — номер последнего сообщения чата, полученного клиентом; 0 означает момент обрыва.
+func (r *Room) resumeSession(token string, c *threadSafeWriter, lastChat uint64) (*peerConnectionState, error) {
+	r.ListLock.Lock()
+
+	var pcState *peerConnectionState
+	for _, peer := range r.Peers {
+		if subtle.ConstantTimeCompare([]byte(peer.resumeToken), []byte(token)) == 1 {
+			pcState = peer
+			break
+		}
+	}
+
+	if pcState == nil || pcState.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed {
+		r.ListLock.Unlock()
+		return nil, newSignalingError(errCodeSessionExpired, errSessionExpired)
+	}
+
+	// Таймер уже сработал — сессия закрывается
+	if pcState.resumeTimer != nil && !pcState.resumeTimer.Stop() {
+		r.ListLock.Unlock()
+		return nil, newSignalingError(errCodeSessionExpired, errSessionExpired)
+	}
+	pcState.resumeTimer = nil
+
+	if lastChat == 0 {
+		lastChat = pcState.chatMark
+	}
+	missed := make([]ChatMessage, 0)
+	for _, message := range r.ChatHistory {
+		if message.ID > lastChat {
+			missed = append(missed, message)
+		}
+	}
+
+	previous := pcState.websocket.Swap(c)
+	r.ListLock.Unlock()
+
+	// Старое соединение могло ещё не заметить обрыв
+	if previous != nil && previous != c {
+		previous.Close()
+	}
+
+	pcState.log.Info("Session resumed")
+
+	if err := pcState.sendSession(); err != nil {
+		pcState.log.Error("Failed to send session", "err", err)
+	}
+	// Учётные данные TURN могли истечь, перезапуск ICE возьмёт новые
+	if err := r.server.sendICEServers(pcState); err != nil {
+		pcState.log.Error("Failed to send ICE servers", "err", err)
+	}
+	if err := r.sendRoster(pcState); err != nil {
+		pcState.log.Error("Failed to send roster", "err", err)
+	}
+	if len(missed) > 0 {
+		if err := c.WriteEvent("chat_history", missed); err != nil {
+			pcState.log.Error("Failed to send missed chat", "err", err)
+		}
+	}
+
+	r.resumeNegotiation(pcState)
+
+	return pcState, nil
+}
+
+// detachSession вызывается при обрыве websocket сессии. Нормальное закрытие (участник вышел)
+// завершает сессию сразу, иначе PeerConnection ждёт переподключения resumeGracePeriod.
+// Закрытие без кода (1005, ws.close() в браузере) тоже считается выходом: при обрыве связи кадра закрытия нет.
+func (r *Room) detachSession(pcState *peerConnectionState, c *threadSafeWriter, closeErr error) {
+	if websocket.IsCloseError(closeErr, websocket.CloseNormalClosure, websocket.CloseNoStatusReceived) {
+		if err := pcState.peerConnection.Close(); err != nil {
+			pcState.log.Error("Failed to close PeerConnection", "err", err)
+		}
+		return
+	}
+
+	r.ListLock.Lock()
+	defer r.ListLock.Unlock()
+
+	// Сессия уже продолжена другим соединением
+	if pcState.websocket.Load() != c {
+		return
+	}
+
+	pcState.chatMark = r.chatSeq
+	pcState.resumeTimer = time.AfterFunc(resumeGracePeriod, func() {
+		pcState.log.Info("Session was not resumed, closing")
+
+		if err := pcState.peerConnection.Close(); err != nil {
+			pcState.log.Error("Failed to close PeerConnection", "err", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+)
+
+// Закрытие websocket с кодом 1000 или без кода завершает сессию сразу, обрыв оставляет её ждать переподключения
+func TestDetachSessionOnClose(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		close func(ws *websocket.Conn) error
+		leave bool
+	}{
+		{
+			name: "normal closure",
+			close: func(ws *websocket.Conn) error {
+				return ws.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "leave"))
+			},
+			leave: true,
+		},
+		{
+			name: "no status",
+			close: func(ws *websocket.Conn) error {
+				return ws.WriteMessage(websocket.CloseMessage, nil)
+			},
+			leave: true,
+		},
+		{
+			name: "dropped connection",
+			close: func(ws *websocket.Conn) error {
+				return ws.UnderlyingConn().Close()
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, client, peer := joinTestPeer(t)
+			client.offer()
+
+			if err := tc.close(client.ws); err != nil {
+				t.Fatal(err)
+			}
+
+			closed := func() bool {
+				return peer.peerConnection.ConnectionState() == webrtc.PeerConnectionStateClosed
+			}
+			if tc.leave {
+				waitFor(t, "closed PeerConnection", closed)
+				return
+			}
+
+			time.Sleep(300 * time.Millisecond)
+			if closed() {
+				t.Fatal("dropped session closed without waiting for a resume")
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Версии протокола сигнализации выбираются подпротоколом WebSocket.
+// Клиент без подпротокола (текущий script.js) работает по устаревшему формату v0 — websocketMessage
+// с событием в поле event и полезной нагрузкой, закодированной строкой JSON в поле data.
+const (
+	signalingProtocolV1 = "webrtc-signaling.v1"
+)
+
+// Коды ошибок протокола сигнализации
+const (
+	errCodeBadRequest        = "bad_request"
+	errCodeUnknownType       = "unknown_type"
+	errCodeNegotiation       = "negotiation_failed"
+	errCodeGlare             = "glare"
+	errCodeForbidden         = "forbidden"
+	errCodeScreenShareDenied = "screen_share_denied"
+	errCodeSessionExpired    = "session_expired"
+	errCodeInternal          = "internal_error"
+)
+
+// Служебные типы сообщений v1
+const (
+	signalingTypeError = "error"
+	signalingTypeAck   = "ack"
+)
+
+// signalingMessage — сообщение протокола v1. RequestID из запроса клиента возвращается в ack или error.
+type signalingMessage struct {
+	Type      string          `json:"type"`
+	RequestID string          `json:"request_id,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// signalingError — ошибка обработки сообщения, отправляемая клиенту событием error
+type signalingError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *signalingError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func newSignalingError(code string, err error) *signalingError {
+	return &signalingError{Code: code, Message: err.Error()}
+}
+
+// Полезные нагрузки сообщений
+type (
+	offerPayload struct {
+		Description webrtc.SessionDescription `json:"description"`
+		Tracks      map[string]trackInfo      `json:"tracks,omitempty"`
+	}
+
+	chatPayload struct {
+		ID        uint64    `json:"id,omitempty"`
+		Sender    string    `json:"sender,omitempty"`
+		Text      string    `json:"text"`
+		Timestamp time.Time `json:"timestamp,omitempty"`
+	}
+)
+
+// incomingSignal — сообщение клиента, приведённое к общему виду независимо от версии протокола
+type incomingSignal struct {
+	Type      string
+	RequestID string
+	Payload   json.RawMessage
+}
+
+// WriteEvent кодирует событие в протокол, согласованный с клиентом, и отправляет его
+func (t *threadSafeWriter) WriteEvent(event string, payload interface{}) error {
+	if t.protocol == signalingProtocolV1 {
+		return t.writeSignal(event, "", payload)
+	}
+
+	message, err := legacyMessage(event, payload)
+	if err != nil {
+		return err
+	}
+
+	return t.WriteJSON(message)
+}
+
+// WriteError сообщает клиенту об ошибке обработки запроса requestID
+func (t *threadSafeWriter) WriteError(requestID string, err error) error {
+	var sigErr *signalingError
+	if !errors.As(err, &sigErr) {
+		sigErr = newSignalingError(errCodeInternal, err)
+	}
+
+	if t.protocol == signalingProtocolV1 {
+		return t.writeSignal(signalingTypeError, requestID, sigErr)
+	}
+
+	return t.WriteEvent(signalingTypeError, sigErr)
+}
+
+// WriteAck подтверждает успешную обработку запроса. В v0 подтверждений нет.
+func (t *threadSafeWriter) WriteAck(requestID string) error {
+	if t.protocol != signalingProtocolV1 || requestID == "" {
+		return nil
+	}
+
+	return t.writeSignal(signalingTypeAck, requestID, nil)
+}
+
+func (t *threadSafeWriter) writeSignal(messageType, requestID string, payload interface{}) error {
+	message := signalingMessage{Type: messageType, RequestID: requestID}
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		message.Payload = data
+	}
+
+	return t.WriteJSON(&message)
+}
+
+// decodeSignal разбирает сообщение клиента согласно версии протокола
+func (t *threadSafeWriter) decodeSignal(raw []byte) (incomingSignal, error) {
+	if t.protocol == signalingProtocolV1 {
+		message := signalingMessage{}
+		if err := json.Unmarshal(raw, &message); err != nil {
+			return incomingSignal{}, err
+		}
+
+		return incomingSignal{Type: message.Type, RequestID: message.RequestID, Payload: message.Payload}, nil
+	}
+
+	message := websocketMessage{}
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return incomingSignal{}, err
+	}
+
+	signal := incomingSignal{Type: message.Event, Payload: json.RawMessage(message.Data)}
+	if message.Event == "chat" {
+		data, err := json.Marshal(chatPayload{Sender: message.Sender, Text: message.Text})
+		if err != nil {
+			return incomingSignal{}, err
+		}
+		signal.Payload = data
+	}
+
+	return signal, nil
+}
+
+// legacyMessage переводит событие в формат v0, который ожидает script.js
+func legacyMessage(event string, payload interface{}) (*websocketMessage, error) {
+	switch p := payload.(type) {
+	case offerPayload:
+		data, err := json.Marshal(p.Description)
+		if err != nil {
+			return nil, err
+		}
+		return &websocketMessage{Event: event, Data: string(data), Tracks: p.Tracks}, nil
+	case chatPayload:
+		return &websocketMessage{Event: event, Sender: p.Sender, Text: p.Text, ID: p.ID}, nil
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	message := &websocketMessage{Event: event, Data: string(data)}
+	if event == "chat_history" {
+		message.Sender = "system"
+	}
+
+	return message, nil
+}
+
+// handleSignal выполняет запрос участника. Ошибка возвращается клиенту событием error.
+func (r *Room) handleSignal(pcState *peerConnectionState, signal incomingSignal) error {
+	peerConnection := pcState.peerConnection
+
+	switch signal.Type {
+	case "candidate":
+		candidate := webrtc.ICECandidateInit{}
+		if err := json.Unmarshal(signal.Payload, &candidate); err != nil {
+			return newSignalingError(errCodeBadRequest, err)
+		}
+
+		pcState.log.Debug("Got candidate", "candidate", candidate.Candidate)
+
+		if err := peerConnection.AddICECandidate(candidate); err != nil {
+			return newSignalingError(errCodeNegotiation, err)
+		}
+	case "answer":
+		answer := webrtc.SessionDescription{}
+		if err := json.Unmarshal(signal.Payload, &answer); err != nil {
+			return newSignalingError(errCodeBadRequest, err)
+		}
+
+		pcState.log.Debug("Got answer")
+
+		if err := r.acceptAnswer(pcState, answer); err != nil {
+			return newSignalingError(errCodeNegotiation, err)
+		}
+	case "offer":
+		offer := webrtc.SessionDescription{}
+		if err := json.Unmarshal(signal.Payload, &offer); err != nil {
+			return newSignalingError(errCodeBadRequest, err)
+		}
+
+		pcState.log.Debug("Got offer")
+
+		if err := r.acceptOffer(pcState, offer); err != nil {
+			if errors.Is(err, errGlare) {
+				return newSignalingError(errCodeGlare, err)
+			}
+			return newSignalingError(errCodeNegotiation, err)
+		}
+	case "track_mute", "track_unmute":
+		req := trackMuteRequest{}
+		if err := json.Unmarshal(signal.Payload, &req); err != nil {
+			return newSignalingError(errCodeBadRequest, err)
+		}
+
+		if err := r.setTrackMuted(pcState, req.TrackID, signal.Type == "track_mute"); err != nil {
+			return newSignalingError(errCodeForbidden, err)
+		}
+	case "screen_share_start":
+		if err := r.startScreenShare(pcState); err != nil {
+			return newSignalingError(errCodeScreenShareDenied, err)
+		}
+	case "screen_share_stop":
+		if err := r.stopScreenShare(pcState); err != nil {
+			return err
+		}
+	case "chat":
+		chat := chatPayload{}
+		if err := json.Unmarshal(signal.Payload, &chat); err != nil {
+			return newSignalingError(errCodeBadRequest, err)
+		}
+
+		// Отправителем всегда считается сам участник, имя из сообщения не используется
+		message := r.addChatMessage(pcState.username, chat.Text)
+
+		// Рассылаем сообщение всем участникам комнаты
+		r.broadcastEvent("chat", chatPayload(message), nil)
+	default:
+		return newSignalingError(errCodeUnknownType, fmt.Errorf("unknown message type %q", signal.Type))
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Источники треков
+const (
+	trackSourceCamera     = "camera"
+	trackSourceMicrophone = "microphone"
+	trackSourceScreen     = "screen"
+)
+
+// trackInfo связывает трек с опубликовавшим его участником
+type trackInfo struct {
+	TrackID       string `json:"track_id"`
+	StreamID      string `json:"stream_id"`
+	ParticipantID string `json:"participant_id"`
+	Username      string `json:"username"`
+	Kind          string `json:"kind"`
+	Source        string `json:"source"`
+	Muted         bool   `json:"muted"`
+
+	// paused читается в цикле пересылки RTP без блокировки комнаты
+	paused *atomic.Bool
+	// relayed — трек пришёл с другого узла и не пересылается на другие узлы
+	relayed bool
+}
+
+// trackMuteRequest — данные событий track_mute и track_unmute от клиента
+type trackMuteRequest struct {
+	TrackID string `json:"track_id"`
+}
+
+var (
+	errTrackNotFound = errors.New("track not found")
+	errTrackNotOwned = errors.New("track belongs to another participant")
+)
+
+// sourceForKind возвращает источник трека по умолчанию для его типа
+func sourceForKind(kind webrtc.RTPCodecType) string {
+	if kind == webrtc.RTPCodecTypeAudio {
+		return trackSourceMicrophone
+	}
+
+	return trackSourceCamera
+}
+
+// tracksSnapshot копирует реестр треков для отправки клиентам.
+// Вызывается под ListLock комнаты.
+func (r *Room) tracksSnapshot() map[string]trackInfo {
+	snapshot := make(map[string]trackInfo, len(r.Tracks))
+	for trackID, track := range r.Tracks {
+		snapshot[trackID] = *track
+	}
+
+	return snapshot
+}
+
+// setTrackMuted меняет состояние mute трека участника, останавливает или возобновляет
+// его пересылку и оповещает комнату
+func (r *Room) setTrackMuted(owner *peerConnectionState, trackID string, muted bool) error {
+	r.ListLock.Lock()
+	track, ok := r.Tracks[trackID]
+	if !ok {
+		r.ListLock.Unlock()
+		return errTrackNotFound
+	}
+	if track.ParticipantID != owner.id {
+		r.ListLock.Unlock()
+		return errTrackNotOwned
+	}
+
+	track.Muted = muted
+	track.paused.Store(muted)
+	updated := *track
+	r.ListLock.Unlock()
+
+	event := "track_unmute"
+	if muted {
+		event = "track_mute"
+	}
+	r.broadcastEvent(event, updated, nil)
+
+	// После включения подписчикам нужен ключевой кадр, иначе картинка не восстановится сразу
+	if !muted {
+		r.DispatchKeyFrame()
+	}
+
+	return nil
+}
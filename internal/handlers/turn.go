@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/turn/v4"
+)
+
+// Клиентский транспорт без аутентифицированных запросов дольше этого времени не учитывается в квоте.
+// Клиент обновляет выделение не реже раза в 10 минут (время жизни выделения по умолчанию).
+const turnClientIdle = 11 * time.Minute
+
+// StartTURNServer запускает встроенный TURN-сервер, если задан TURN_LISTEN, и возвращает его для закрытия.
+// Учётные данные — временные TURN REST, выдаваемые при входе в комнату.
+func (s *Server) StartTURNServer() (io.Closer, error) {
+	cfg := s.cfg.TURN
+	if cfg.Listen == "" {
+		return nil, nil
+	}
+
+	relayIP := net.ParseIP(cfg.PublicIP)
+
+	if s.cfg.ICE.TURNSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		s.cfg.ICE.TURNSecret = base64.StdEncoding.EncodeToString(secret)
+		s.log.Info("Generated shared secret for the embedded TURN server")
+	}
+
+	_, port, err := net.SplitHostPort(cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	udpListener, err := net.ListenPacket("udp4", cfg.Listen)
+	if err != nil {
+		return nil, err
+	}
+
+	tcpListener, err := net.Listen("tcp4", cfg.Listen)
+	if err != nil {
+		udpListener.Close()
+		return nil, err
+	}
+
+	relayAddressGenerator := func() turn.RelayAddressGenerator {
+		return &turn.RelayAddressGeneratorPortRange{
+			RelayAddress: relayIP,
+			Address:      "0.0.0.0",
+			MinPort:      cfg.RelayPortMin,
+			MaxPort:      cfg.RelayPortMax,
+		}
+	}
+
+	quota := &turnQuota{server: s, limit: cfg.UserQuota, clients: make(map[string]map[string]time.Time)}
+
+	server, err := turn.NewServer(turn.ServerConfig{
+		Realm:       cfg.Realm,
+		AuthHandler: quota.authenticate,
+		PacketConnConfigs: []turn.PacketConnConfig{{
+			PacketConn:            udpListener,
+			RelayAddressGenerator: relayAddressGenerator(),
+		}},
+		ListenerConfigs: []turn.ListenerConfig{{
+			Listener:              tcpListener,
+			RelayAddressGenerator: relayAddressGenerator(),
+		}},
+		LoggerFactory: s.loggerFactory,
+	})
+	if err != nil {
+		udpListener.Close()
+		tcpListener.Close()
+		return nil, err
+	}
+
+	host := net.JoinHostPort(relayIP.String(), port)
+	s.turnURLs = []string{"turn:" + host + "?transport=udp", "turn:" + host + "?transport=tcp"}
+
+	s.log.Info("Embedded TURN server listening", "addr", cfg.Listen, "relay_ip", relayIP, "port_min", cfg.RelayPortMin, "port_max", cfg.RelayPortMax)
+
+	return server, nil
+}
+
+// turnQuota ограничивает число одновременных клиентских транспортов TURN одного участника.
+// Каждое выделение принадлежит своему адресу клиента, поэтому квота считается по адресам,
+// от которых участник проходил аутентификацию за последние turnClientIdle.
+type turnQuota struct {
+	sync.Mutex
+	server  *Server
+	limit   int
+	clients map[string]map[string]time.Time // Участник → адрес клиента → последняя аутентификация
+	purged  time.Time
+}
+
+// authenticate проверяет временные учётные данные "<срок действия>:<id участника>".
+// Они действуют, пока участник остаётся в комнате, и не дольше срока действия.
+func (q *turnQuota) authenticate(username, realm string, srcAddr net.Addr) ([]byte, bool) {
+	expiry, participantID, found := strings.Cut(username, ":")
+	if !found {
+		return nil, false
+	}
+
+	expiresAt, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || expiresAt < time.Now().Unix() {
+		return nil, false
+	}
+
+	if !q.server.participantJoined(participantID) {
+		q.server.log.Info("TURN credentials rejected, participant left", "peer", participantID)
+		return nil, false
+	}
+
+	if !q.admit(participantID, srcAddr) {
+		q.server.log.Warn("TURN quota exceeded", "peer", participantID)
+		return nil, false
+	}
+
+	return turn.GenerateAuthKey(username, realm, q.server.turnPassword(username)), true
+}
+
+// admit учитывает адрес клиента участника и отказывает новому адресу сверх квоты
+func (q *turnQuota) admit(participantID string, srcAddr net.Addr) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	now := time.Now()
+	if now.Sub(q.purged) > time.Minute {
+		for id, clients := range q.clients {
+			for addr, seen := range clients {
+				if now.Sub(seen) > turnClientIdle {
+					delete(clients, addr)
+				}
+			}
+			if len(clients) == 0 {
+				delete(q.clients, id)
+			}
+		}
+		q.purged = now
+	}
+
+	clients, ok := q.clients[participantID]
+	if !ok {
+		clients = make(map[string]time.Time)
+		q.clients[participantID] = clients
+	}
+
+	addr := srcAddr.String()
+	if _, known := clients[addr]; !known && len(clients) >= q.limit {
+		return false
+	}
+	clients[addr] = now
+
+	return true
+}
+
+// participantJoined сообщает, состоит ли участник (или сессия WHIP/WHEP) в какой-либо комнате
+func (s *Server) participantJoined(participantID string) bool {
+	s.roomsLock.RLock()
+	rooms := make([]*Room, 0, len(s.rooms))
+	for _, room := range s.rooms {
+		rooms = append(rooms, room)
+	}
+	s.roomsLock.RUnlock()
+
+	for _, room := range rooms {
+		room.ListLock.RLock()
+		_, joined := room.Publishers[participantID]
+		if _, ok := room.Viewers[participantID]; ok {
+			joined = true
+		}
+		for _, peer := range room.Peers {
+			if peer.id == participantID {
+				joined = true
+				break
+			}
+		}
+		room.ListLock.RUnlock()
+
+		if joined {
+			return true
+		}
+	}
+
+	return false
+}
+
+// turnPassword вычисляет пароль TURN REST API: base64(HMAC-SHA1(secret, username))
+func (s *Server) turnPassword(username string) string {
+	mac := hmac.New(sha1.New, []byte(s.cfg.ICE.TURNSecret))
+	mac.Write([]byte(username))
+
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// turnServerURLs возвращает адреса TURN для клиентов: заданные ICE_TURN_SERVERS и встроенного сервера
+func (s *Server) turnServerURLs() []string {
+	return append(append([]string{}, s.cfg.ICE.TURNServers...), s.turnURLs...)
+}
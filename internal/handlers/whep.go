@@ -0,0 +1,213 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	defaultViewerUsername = "viewer"
+
+	// Собственное расширение проекта: GET на ресурс сессии отдаёт поток SSE с offer повторного согласования.
+	// Это не расширение server-sent-events из черновика WHEP, стандартные клиенты его не поддерживают.
+	whepRenegotiationRel = "urn:x-webrtc-app:whep:renegotiation"
+)
+
+// WHEPHandler создаёт сессию просмотра комнаты по WHEP: POST /whep/{room}.
+// Зритель получает все TrackLocals комнаты, но не входит в её состав и не виден участникам.
+func (s *Server) WHEPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.rejectDraining(w) {
+		return
+	}
+
+	room, ok := s.authorizeRoomBearer(w, r)
+	if !ok {
+		return
+	}
+
+	offer, ok := readSessionDescription(w, r)
+	if !ok {
+		return
+	}
+
+	peerConnection, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		room.requestLog(r).Error("Failed to create PeerConnection", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	pcState := &peerConnectionState{
+		id:             uuid.NewString(),
+		peerConnection: peerConnection,
+		username:       defaultViewerUsername,
+		role:           roleViewer,
+		offers:         make(chan string, 1),
+		ended:          make(chan struct{}),
+	}
+	pcState.log = room.requestLog(r).With("peer", pcState.id)
+
+	var endOnce sync.Once
+	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		pcState.log.Info("WHEP connection state change", "state", p.String())
+
+		switch p {
+		case webrtc.PeerConnectionStateFailed:
+			if err := peerConnection.Close(); err != nil {
+				pcState.log.Error("Failed to close PeerConnection", "err", err)
+			}
+		case webrtc.PeerConnectionStateClosed:
+			endOnce.Do(func() { close(pcState.ended) })
+			room.signalPeerConnections()
+		default:
+		}
+	})
+
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	}); err != nil {
+		pcState.log.Error("Failed to set WHEP offer", "err", err)
+		peerConnection.Close()
+		http.Error(w, "Invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	// Текущие треки занимают предложенные клиентом трансиверы,
+	// остальные придут при следующем согласовании через поток событий
+	room.ListLock.Lock()
+	for _, trackLocal := range room.TrackLocals {
+		if _, err := peerConnection.AddTrack(trackLocal); err != nil {
+			pcState.log.Error("Failed to add track to WHEP session", "err", err)
+		}
+	}
+	room.Viewers[pcState.id] = pcState
+	room.ListLock.Unlock()
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err == nil {
+		gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+		if err = peerConnection.SetLocalDescription(answer); err == nil {
+			<-gatherComplete
+		}
+	}
+	if err != nil {
+		pcState.log.Error("Failed to answer WHEP offer", "err", err)
+		peerConnection.Close()
+		http.Error(w, "Invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	resource := "/whep/" + url.PathEscape(room.Name) + "/" + pcState.id
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", resource)
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="%s"; events="offer"`, resource, whepRenegotiationRel))
+	s.setICEServerLinks(w, pcState.id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(peerConnection.LocalDescription().SDP))
+
+	// Треки, не поместившиеся в answer, досылаются повторным согласованием
+	room.signalPeerConnections()
+}
+
+// WHEPResourceHandler обслуживает ресурс WHEP-сессии:
+// GET — поток событий (offer повторного согласования), PATCH — trickle ICE или answer, DELETE — завершение
+func (s *Server) WHEPResourceHandler(w http.ResponseWriter, r *http.Request) {
+	room, ok := s.authorizeRoomBearer(w, r)
+	if !ok {
+		return
+	}
+
+	room.ListLock.RLock()
+	pcState, ok := room.Viewers[r.PathValue("session")]
+	room.ListLock.RUnlock()
+
+	if !ok {
+		http.Error(w, "Session does not exist", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		streamViewerOffers(w, r, pcState)
+	case http.MethodPatch:
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if mediaType == sdpContentType {
+			s.handleViewerAnswer(w, r, room, pcState)
+			return
+		}
+		s.handleTrickleICE(w, r, pcState.peerConnection)
+	case http.MethodDelete:
+		if err := pcState.peerConnection.Close(); err != nil {
+			pcState.log.Error("Failed to close PeerConnection", "err", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// streamViewerOffers отдаёт зрителю offer'ы повторного согласования как Server-Sent Events,
+// пока не завершится сессия или запрос. Ответ на каждый offer клиент присылает через PATCH с Content-Type application/sdp.
+func streamViewerOffers(w http.ResponseWriter, r *http.Request, pcState *peerConnectionState) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case offer := <-pcState.offers:
+			fmt.Fprint(w, "event: offer\n")
+			for _, line := range strings.Split(strings.TrimRight(offer, "\r\n"), "\n") {
+				fmt.Fprintf(w, "data: %s\n", strings.TrimRight(line, "\r"))
+			}
+			fmt.Fprint(w, "\n")
+			flusher.Flush()
+		case <-pcState.ended:
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// handleViewerAnswer применяет answer зрителя на offer повторного согласования
+func (s *Server) handleViewerAnswer(w http.ResponseWriter, r *http.Request, room *Room, pcState *peerConnectionState) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescription))
+	if err != nil || len(body) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := room.acceptAnswer(pcState, webrtc.SessionDescription{
+		Type: webrtc.SDPTypeAnswer,
+		SDP:  string(body),
+	}); err != nil {
+		pcState.log.Error("Failed to set WHEP answer", "err", err)
+		http.Error(w, "Invalid answer", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// Поток событий зрителя WHEP закрывается вместе с сессией, а не только при обрыве запроса
+func TestWHEPEventStreamEndsWithSession(t *testing.T) {
+	s := newTestServer(t)
+	ts := serveTestServer(t, s)
+	createTestRoom(t, s, ts.URL, "watched")
+
+	pc, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+
+	if _, err := pc.AddTransceiverFromKind(webrtc.RTPCodecTypeVideo, webrtc.RTPTransceiverInit{Direction: webrtc.RTPTransceiverDirectionRecvonly}); err != nil {
+		t.Fatal(err)
+	}
+	offer, err := pc.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gatherComplete := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(offer); err != nil {
+		t.Fatal(err)
+	}
+	<-gatherComplete
+
+	resource := whepRequest(t, http.MethodPost, ts.URL+"/whep/watched", pc.LocalDescription().SDP, http.StatusCreated).Header.Get("Location")
+
+	events := whepRequest(t, http.MethodGet, ts.URL+resource, "", http.StatusOK)
+	ended := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, events.Body)
+		close(ended)
+	}()
+
+	whepRequest(t, http.MethodDelete, ts.URL+resource, "", http.StatusOK).Body.Close()
+
+	select {
+	case <-ended:
+	case <-time.After(testTimeout):
+		t.Fatal("event stream is still open after the session was deleted")
+	}
+}
+
+func whepRequest(t *testing.T, method, url, sdp string, status int) *http.Response {
+	t.Helper()
+
+	request, err := http.NewRequest(method, url, strings.NewReader(sdp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer pw")
+	if sdp != "" {
+		request.Header.Set("Content-Type", sdpContentType)
+	}
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != status {
+		t.Fatalf("%s %s: %s", method, url, response.Status)
+	}
+
+	return response
+}
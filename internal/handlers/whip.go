@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+)
+
+const (
+	sdpContentType         = "application/sdp"
+	trickleICEContentType  = "application/trickle-ice-sdpfrag"
+	defaultIngestUsername  = "ingest"
+	maxSessionDescription  = 1 << 20
+	bearerAuthSchemePrefix = "Bearer "
+)
+
+var errICERestartUnsupported = errors.New("ICE restart is not supported")
+
+// WHIPHandler принимает публикацию потока в комнату по WHIP (RFC 9725): POST /whip/{room}.
+// Токеном Bearer служит пароль комнаты, треки публикуются через тот же Room.addTrack, что и у websocket-участников.
+func (s *Server) WHIPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.rejectDraining(w) {
+		return
+	}
+
+	room, ok := s.authorizeRoomBearer(w, r)
+	if !ok {
+		return
+	}
+
+	offer, ok := readSessionDescription(w, r)
+	if !ok {
+		return
+	}
+
+	username := r.URL.Query().Get("username")
+	if username == "" {
+		username = defaultIngestUsername
+	}
+
+	peerConnection, err := s.api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		room.requestLog(r).Error("Failed to create PeerConnection", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	pcState := &peerConnectionState{
+		id:             uuid.NewString(),
+		peerConnection: peerConnection,
+		username:       username,
+		role:           roleIngest,
+	}
+	pcState.log = room.requestLog(r).With("peer", pcState.id)
+
+	peerConnection.OnTrack(func(t *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		room.forwardTrack(pcState, t, receiver)
+	})
+
+	peerConnection.OnConnectionStateChange(func(p webrtc.PeerConnectionState) {
+		pcState.log.Info("WHIP connection state change", "state", p.String())
+
+		switch p {
+		case webrtc.PeerConnectionStateFailed:
+			if err := peerConnection.Close(); err != nil {
+				pcState.log.Error("Failed to close PeerConnection", "err", err)
+			}
+		case webrtc.PeerConnectionStateClosed:
+			room.removePublisher(pcState)
+		default:
+		}
+	})
+
+	answer, err := answerOffer(peerConnection, offer)
+	if err != nil {
+		pcState.log.Error("Failed to answer WHIP offer", "err", err)
+		peerConnection.Close()
+		http.Error(w, "Invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	room.addPublisher(pcState)
+
+	w.Header().Set("Content-Type", sdpContentType)
+	w.Header().Set("Location", "/whip/"+url.PathEscape(room.Name)+"/"+pcState.id)
+	s.setICEServerLinks(w, pcState.id)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+// WHIPResourceHandler обслуживает ресурс WHIP-сессии: PATCH для trickle ICE и DELETE для завершения
+func (s *Server) WHIPResourceHandler(w http.ResponseWriter, r *http.Request) {
+	room, ok := s.authorizeRoomBearer(w, r)
+	if !ok {
+		return
+	}
+
+	room.ListLock.RLock()
+	pcState, ok := room.Publishers[r.PathValue("session")]
+	room.ListLock.RUnlock()
+
+	if !ok {
+		http.Error(w, "Session does not exist", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPatch:
+		s.handleTrickleICE(w, r, pcState.peerConnection)
+	case http.MethodDelete:
+		if err := pcState.peerConnection.Close(); err != nil {
+			pcState.log.Error("Failed to close PeerConnection", "err", err)
+		}
+		room.removePublisher(pcState)
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorizeRoomBearer находит комнату из пути запроса и проверяет токен Bearer (пароль комнаты)
+func (s *Server) authorizeRoomBearer(w http.ResponseWriter, r *http.Request) (*Room, bool) {
+	roomName := r.PathValue("room")
+
+	if s.forwardToRoomOwner(w, r, roomName, false) {
+		return nil, false
+	}
+
+	s.roomsLock.RLock()
+	room, exists := s.rooms[roomName]
+	password := s.passwords[roomName]
+	s.roomsLock.RUnlock()
+
+	if !exists {
+		http.Error(w, "Room does not exist", http.StatusNotFound)
+		return nil, false
+	}
+
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), bearerAuthSchemePrefix)
+	if !found || subtle.ConstantTimeCompare([]byte(token), []byte(password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="webrtc"`)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return room, true
+}
+
+// readSessionDescription читает SDP из тела запроса с Content-Type application/sdp
+func readSessionDescription(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != sdpContentType {
+		http.Error(w, "Content-Type must be application/sdp", http.StatusUnsupportedMediaType)
+		return "", false
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescription))
+	if err != nil || len(body) == 0 {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return "", false
+	}
+
+	return string(body), true
+}
+
+// answerOffer применяет offer клиента и возвращает answer со всеми собранными кандидатами
+func answerOffer(peerConnection *webrtc.PeerConnection, offer string) (string, error) {
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{
+		Type: webrtc.SDPTypeOffer,
+		SDP:  offer,
+	}); err != nil {
+		return "", err
+	}
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gatherComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gatherComplete
+
+	return peerConnection.LocalDescription().SDP, nil
+}
+
+// handleTrickleICE добавляет кандидатов из тела PATCH (application/trickle-ice-sdpfrag)
+func (s *Server) handleTrickleICE(w http.ResponseWriter, r *http.Request, peerConnection *webrtc.PeerConnection) {
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != trickleICEContentType {
+		http.Error(w, "Content-Type must be application/trickle-ice-sdpfrag", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSessionDescription))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := addSDPFragCandidates(peerConnection, string(body)); err != nil {
+		if errors.Is(err, errICERestartUnsupported) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		s.requestLog(r).Error("Failed to add trickled ICE candidate", "err", err)
+		http.Error(w, "Invalid ICE candidate", http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// addSDPFragCandidates разбирает SDP-фрагмент и добавляет кандидатов к соединению.
+// Фрагмент со сменой ice-ufrag означает ICE restart, который здесь не поддерживается.
+func addSDPFragCandidates(peerConnection *webrtc.PeerConnection, frag string) error {
+	remoteUfrag := ""
+	if remote := peerConnection.RemoteDescription(); remote != nil {
+		remoteUfrag = sdpAttribute(remote.SDP, "ice-ufrag")
+	}
+
+	var mid *string
+	scanner := bufio.NewScanner(strings.NewReader(frag))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "a=ice-ufrag:"):
+			if ufrag := strings.TrimPrefix(line, "a=ice-ufrag:"); remoteUfrag != "" && ufrag != remoteUfrag {
+				return errICERestartUnsupported
+			}
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			if err := peerConnection.AddICECandidate(webrtc.ICECandidateInit{
+				Candidate: strings.TrimPrefix(line, "a="),
+				SDPMid:    mid,
+			}); err != nil {
+				return err
+			}
+		}
+	}
+
+	return scanner.Err()
+}
+
+// sdpAttribute возвращает значение первого атрибута a=<name>: в SDP
+func sdpAttribute(sdp, name string) string {
+	prefix := "a=" + name + ":"
+	for _, line := range strings.Split(sdp, "\n") {
+		if value, found := strings.CutPrefix(strings.TrimSpace(line), prefix); found {
+			return value
+		}
+	}
+
+	return ""
+}
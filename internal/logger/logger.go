@@ -0,0 +1,167 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"webrtc-app/internal/config"
+
+	"github.com/google/uuid"
+	"github.com/pion/logging"
+)
+
+// Структурное логирование узла на log/slog. Логи pion идут через тот же обработчик
+// с полем scope, запросы HTTP получают request_id.
+
+const (
+	// LevelTrace — подробнее debug: пакеты, кандидаты и SDP целиком
+	LevelTrace = slog.Level(-8)
+	// levelDisabled выше любого уровня записи
+	levelDisabled = slog.Level(100)
+
+	// Заголовок с идентификатором запроса, принимается от прокси и возвращается клиенту
+	requestIDHeader = "X-Request-ID"
+	// Длина принимаемого идентификатора запроса, длинные заменяются своими
+	maxRequestIDLength = 128
+)
+
+// ParseLevel переводит LOG_LEVEL в уровень slog
+func ParseLevel(level string) slog.Level {
+	switch level {
+	case "trace":
+		return LevelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "disabled":
+		return levelDisabled
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// New создаёт логгер по LOG_LEVEL и LOG_FORMAT, вывод в stderr
+func New(cfg config.LogConfig) *slog.Logger {
+	options := &slog.HandlerOptions{
+		Level: ParseLevel(cfg.Level),
+		ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.LevelKey && attr.Value.Any() == LevelTrace {
+				attr.Value = slog.StringValue("TRACE")
+			}
+			return attr
+		},
+	}
+
+	if cfg.Format == "json" {
+		return slog.New(slog.NewJSONHandler(os.Stderr, options))
+	}
+
+	return slog.New(slog.NewTextHandler(os.Stderr, options))
+}
+
+// loggerFactory передаёт логи pion (ICE, DTLS, SCTP и т.п.) в slog
+type loggerFactory struct {
+	log *slog.Logger
+}
+
+// NewLoggerFactory возвращает LoggerFactory для SettingEngine pion и встроенного TURN-сервера
+func NewLoggerFactory(log *slog.Logger) logging.LoggerFactory {
+	return loggerFactory{log: log}
+}
+
+func (f loggerFactory) NewLogger(scope string) logging.LeveledLogger {
+	return leveledLogger{log: f.log.With("scope", scope)}
+}
+
+// leveledLogger — logging.LeveledLogger поверх slog. Сообщение форматируется, только если уровень включён.
+type leveledLogger struct {
+	log *slog.Logger
+}
+
+func (l leveledLogger) logf(level slog.Level, format string, args ...interface{}) {
+	if !l.log.Enabled(context.Background(), level) {
+		return
+	}
+	l.log.Log(context.Background(), level, fmt.Sprintf(format, args...))
+}
+
+func (l leveledLogger) Trace(msg string) {
+	l.logf(LevelTrace, "%s", msg)
+}
+
+func (l leveledLogger) Tracef(format string, args ...interface{}) {
+	l.logf(LevelTrace, format, args...)
+}
+
+func (l leveledLogger) Debug(msg string) {
+	l.logf(slog.LevelDebug, "%s", msg)
+}
+
+func (l leveledLogger) Debugf(format string, args ...interface{}) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+
+func (l leveledLogger) Info(msg string) {
+	l.logf(slog.LevelInfo, "%s", msg)
+}
+
+func (l leveledLogger) Infof(format string, args ...interface{}) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+
+func (l leveledLogger) Warn(msg string) {
+	l.logf(slog.LevelWarn, "%s", msg)
+}
+
+func (l leveledLogger) Warnf(format string, args ...interface{}) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+
+func (l leveledLogger) Error(msg string) {
+	l.logf(slog.LevelError, "%s", msg)
+}
+
+func (l leveledLogger) Errorf(format string, args ...interface{}) {
+	l.logf(slog.LevelError, format, args...)
+}
+
+type requestIDKey struct{}
+
+// RequestID присваивает запросу идентификатор: берёт X-Request-ID от прокси или создаёт новый,
+// кладёт его в контекст запроса и возвращает клиенту в том же заголовке
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFrom возвращает идентификатор запроса из контекста, пустой вне RequestID
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID отбрасывает пустые, слишком длинные и непечатные идентификаторы,
+// чтобы клиент не мог подделать строки лога
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	return !strings.ContainsFunc(id, func(r rune) bool {
+		return r < 0x21 || r > 0x7e
+	})
+}
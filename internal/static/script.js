@@ -3,7 +3,22 @@ let ws;
 let username = '';
 let currentRoom = '';
 let localStream;
-const userVideos = {};
+let userVideos = {};
+let dataChannels = {};
+let participants = {};
+let selfId = '';
+let trackMeta = {};
+let screenStream = null;
+let screenTransceiver = null;
+let sessionToken = '';
+let resumeTimeout = 0;
+let reconnectDeadline = 0;
+let lastChatId = 0;
+let leaving = false;
+let baseWsURL = '';
+// Perfect negotiation: the client is the polite side and rolls back its own offer on glare
+let makingOffer = false;
+let offerQueued = false;
 // Autofill fields from URL parameters
 window.addEventListener('DOMContentLoaded', () => {
     const urlParams = new URLSearchParams(window.location.search);
@@ -47,11 +62,15 @@ function joinRoom() {
     }).then(data => {
         if (data.status === 'success') {
             currentRoom = roomName;
+            leaving = false;
             document.getElementById('chatHeader').textContent = `Чат: ${roomName}`;
             document.getElementById('chatMessages').innerHTML = '';
             // Hide join form and show leave button
             document.getElementById('joinForm').style.display = 'none';
             document.getElementById('leaveBtn').style.display = 'block';
+            document.getElementById('cameraBtn').style.display = 'block';
+            document.getElementById('microphoneBtn').style.display = 'block';
+            document.getElementById('screenBtn').style.display = 'block';
             connectToRoom(roomName, password, username);
         }
     }).catch(error => {
@@ -62,8 +81,14 @@ function joinRoom() {
 }
 
 function leaveRoom() {
+    leaving = true;
+    sessionToken = '';
+    lastChatId = 0;
+    makingOffer = false;
+    offerQueued = false;
     if (ws && ws.readyState === WebSocket.OPEN) {
-        ws.close();
+        // A normal closure tells the server to end the session instead of waiting for a resume
+        ws.close(1000, 'leave');
     }
     if (pc) {
         pc.close();
@@ -71,6 +96,11 @@ function leaveRoom() {
     if (localStream) {
         localStream.getTracks().forEach(track => track.stop());
     }
+    if (screenStream) {
+        screenStream.getTracks().forEach(track => track.stop());
+    }
+    screenStream = null;
+    screenTransceiver = null;
     // Clear all remote videos
     const videoGrid = document.getElementById('videoGrid');
     while (videoGrid.children.length > 1) {
@@ -79,16 +109,31 @@ function leaveRoom() {
     // Reset UI
     document.getElementById('joinForm').style.display = 'block';
     document.getElementById('leaveBtn').style.display = 'none';
+    document.getElementById('cameraBtn').style.display = 'none';
+    document.getElementById('microphoneBtn').style.display = 'none';
+    document.getElementById('screenBtn').style.display = 'none';
     document.getElementById('localVideo').srcObject = null;
     updateStatus("Disconnected");
     currentRoom = '';
     username = '';
     userVideos = {};
+    dataChannels = {};
+    participants = {};
+    selfId = '';
+    trackMeta = {};
 }
 
-function connectToRoom(roomName, password, username) {
+// The server puts the signaling URL into the page (wss:// behind a TLS-terminating proxy as well)
+function websocketURL() {
+    if (document.body.dataset.wsUrl) {
+        return document.body.dataset.wsUrl;
+    }
     const protocol = location.protocol === "https:" ? "wss" : "ws";
-    const wsURL = `${protocol}://${location.host}/websocket?room=${encodeURIComponent(roomName)}&username=${encodeURIComponent(username)}&password=${encodeURIComponent(password)}`;
+    return `${protocol}://${location.host}/websocket`;
+}
+
+function connectToRoom(roomName, password, username) {
+    const wsURL = `${websocketURL()}?room=${encodeURIComponent(roomName)}&username=${encodeURIComponent(username)}&password=${encodeURIComponent(password)}`;
     startConnection(wsURL);
 }
 
@@ -105,11 +150,8 @@ function startConnection(wsURL) {
         audio: true
     }).then(stream => {
         localStream = stream;
-        pc = new RTCPeerConnection({
-            iceServers: [{
-                urls: 'stun:stun.l.google.com:19302'
-            }]
-        });
+        // ICE servers (with TURN credentials) arrive from the server before the first offer
+        pc = new RTCPeerConnection();
         // Clear previous remote videos
         const videoGrid = document.getElementById('videoGrid');
         while (videoGrid.children.length > 1) {
@@ -117,7 +159,13 @@ function startConnection(wsURL) {
         }
         pc.ontrack = function(event) {
             if (event.track.kind === 'audio') return;
-            const streamUsername = event.track.id.split('_')[0] || 'Participant';
+            const meta = trackMeta[event.track.id];
+            const owner = meta ? participants[meta.participant_id] : participantForTrack(event.track.id);
+            let streamUsername = (meta && meta.username) || (owner && owner.username) || 'Participant';
+            // Screen share gets its own tile next to the presenter's camera
+            if (meta && meta.source === 'screen') {
+                streamUsername += ':screen';
+            }
             if (userVideos[streamUsername]) {
                 userVideos[streamUsername].video.srcObject = event.streams[0];
                 return;
@@ -146,81 +194,265 @@ function startConnection(wsURL) {
                 }
             };
         };
+        pc.ondatachannel = event => {
+            const channel = event.channel;
+            dataChannels[channel.label] = channel;
+            channel.onmessage = e => {
+                if (typeof e.data !== 'string') {
+                    window.dispatchEvent(new CustomEvent('appmessage', {
+                        detail: {
+                            channel: channel.label,
+                            data: e.data
+                        }
+                    }));
+                    return;
+                }
+                try {
+                    const msg = JSON.parse(e.data);
+                    window.dispatchEvent(new CustomEvent('appmessage', {
+                        detail: Object.assign({
+                            channel: channel.label
+                        }, msg)
+                    }));
+                } catch (err) {
+                    console.error("Error parsing data channel message:", err);
+                }
+            };
+            channel.onclose = () => {
+                if (dataChannels[channel.label] === channel) {
+                    delete dataChannels[channel.label];
+                }
+            };
+        };
         document.getElementById('localVideo').srcObject = stream;
         stream.getTracks().forEach(track => {
-            track.id = `${username}_${track.id}`;
             pc.addTrack(track, stream);
         });
-        ws = new WebSocket(wsURL);
         pc.onicecandidate = e => {
-            if (e.candidate) {
+            if (e.candidate && ws && ws.readyState === WebSocket.OPEN) {
                 ws.send(JSON.stringify({
                     event: 'candidate',
                     data: JSON.stringify(e.candidate)
                 }));
             }
         };
-        ws.onclose = () => {
+        pc.onnegotiationneeded = () => sendOffer();
+        baseWsURL = wsURL;
+        openSignaling(wsURL);
+    }).catch(err => {
+        updateStatus("Media access error");
+        alert("Error accessing media devices: " + err.message);
+        console.error("Media error:", err);
+    });
+}
+
+// Open the signaling WebSocket; also used to resume the session after a drop
+function openSignaling(url) {
+    ws = new WebSocket(url);
+    ws.onclose = () => {
+        if (leaving || !sessionToken) {
             updateStatus("Disconnected from room");
-        };
-        ws.onerror = evt => {
-            updateStatus("Connection error");
-            console.error("WebSocket error:", evt);
-        };
-        ws.onmessage = evt => {
-            const msg = JSON.parse(evt.data);
-            if (!msg) {
-                console.error("Failed to parse WebSocket message");
-                return;
-            }
-            switch (msg.event) {
-                case 'offer':
-                    const offer = JSON.parse(msg.data);
-                    pc.setRemoteDescription(offer).then(() => pc.createAnswer()).then(answer => {
-                        pc.setLocalDescription(answer);
+            return;
+        }
+        scheduleReconnect();
+    };
+    ws.onerror = evt => {
+        updateStatus("Connection error");
+        console.error("WebSocket error:", evt);
+    };
+    ws.onmessage = evt => {
+        const msg = JSON.parse(evt.data);
+        if (!msg) {
+            console.error("Failed to parse WebSocket message");
+            return;
+        }
+        switch (msg.event) {
+            case 'offer':
+                trackMeta = msg.tracks || {};
+                const offer = JSON.parse(msg.data);
+                // Glare: the server ignores our offer, so roll it back and repeat it after answering
+                const collision = makingOffer || pc.signalingState !== 'stable';
+                if (collision) {
+                    offerQueued = true;
+                }
+                (collision ? pc.setLocalDescription({
+                    type: 'rollback'
+                }) : Promise.resolve()).then(() => {
+                    return pc.setRemoteDescription(offer);
+                }).then(() => {
+                    attachScreenTrack(offer);
+                    return pc.createAnswer();
+                }).then(answer => {
+                    return pc.setLocalDescription(answer).then(() => {
                         ws.send(JSON.stringify({
                             event: 'answer',
                             data: JSON.stringify(answer)
                         }));
-                        updateStatus("Подключено к заседанию: " + currentRoom);
-                    }).catch(err => {
-                        console.error("Error handling offer:", err);
-                        updateStatus("Connection error");
                     });
+                }).then(() => {
+                    updateStatus("Подключено к заседанию: " + currentRoom);
+                    if (offerQueued) {
+                        offerQueued = false;
+                        sendOffer();
+                    }
+                }).catch(err => {
+                    console.error("Error handling offer:", err);
+                    updateStatus("Connection error");
+                });
+                break;
+            case 'answer':
+                pc.setRemoteDescription(JSON.parse(msg.data)).catch(err => {
+                    console.error("Error applying answer:", err);
+                });
+                break;
+            case 'candidate':
+                const candidate = JSON.parse(msg.data);
+                pc.addIceCandidate(new RTCIceCandidate(candidate)).catch(err => {
+                    console.error("Error adding ICE candidate:", err);
+                });
+                break;
+            case 'session':
+                const session = JSON.parse(msg.data);
+                sessionToken = session.token;
+                resumeTimeout = session.resume_timeout;
+                break;
+            case 'connection_quality':
+                const quality = JSON.parse(msg.data);
+                if (quality.state === 'recovering') {
+                    updateStatus(`Connection lost, reconnecting (${quality.attempt}/${quality.max_attempts})...`);
+                } else if (quality.state === 'unstable') {
+                    updateStatus("Unstable connection");
+                } else if (quality.state === 'lost') {
+                    updateStatus("Connection lost");
+                } else {
+                    updateStatus("Connected to room: " + currentRoom);
+                }
+                break;
+            case 'ice_servers':
+                pc.setConfiguration(Object.assign(pc.getConfiguration(), {
+                    iceServers: JSON.parse(msg.data)
+                }));
+                break;
+            case 'roster':
+                const roster = JSON.parse(msg.data);
+                selfId = roster.self;
+                participants = {};
+                roster.participants.forEach(p => {
+                    participants[p.id] = p;
+                });
+                break;
+            case 'participant_joined':
+            case 'participant_updated':
+                const participant = JSON.parse(msg.data);
+                participants[participant.id] = participant;
+                break;
+            case 'participant_left':
+                delete participants[JSON.parse(msg.data).id];
+                break;
+            case 'track_mute':
+            case 'track_unmute':
+                const mutedTrack = JSON.parse(msg.data);
+                trackMeta[mutedTrack.track_id] = mutedTrack;
+                if (mutedTrack.kind === 'video' && userVideos[mutedTrack.username]) {
+                    userVideos[mutedTrack.username].element.classList.toggle('video-muted', mutedTrack.muted);
+                }
+                break;
+            case 'server_draining':
+                // The server is shutting down: the session cannot be resumed, continue on another node if offered
+                const drain = JSON.parse(msg.data);
+                sessionToken = '';
+                if (drain.redirect) {
+                    const room = currentRoom;
+                    leaveRoom();
+                    location.href = `${drain.redirect}/?room=${encodeURIComponent(room)}`;
                     break;
-                case 'candidate':
-                    const candidate = JSON.parse(msg.data);
-                    pc.addIceCandidate(new RTCIceCandidate(candidate)).catch(err => {
-                        console.error("Error adding ICE candidate:", err);
-                    });
+                }
+                updateStatus("Server is shutting down, the call ends at " + new Date(drain.deadline).toLocaleTimeString());
+                break;
+            case 'error':
+                const signalingError = JSON.parse(msg.data);
+                if (signalingError.code === 'session_expired') {
+                    sessionToken = '';
+                    leaveRoom();
+                    alert("Connection lost, please join the room again");
                     break;
-                case 'chat':
-                    addChatMessage(msg.sender, msg.text);
+                }
+                if (signalingError.code === 'glare') {
+                    // Our offer was rejected; it is repeated once the server's offer is answered
                     break;
-                case 'chat_history':
-                    try {
-                        const history = JSON.parse(msg.data);
-                        history.forEach(item => {
-                            addChatMessage(item.sender, item.text, new Date(item.timestamp));
-                        });
-                    } catch (err) {
-                        console.error("Error parsing chat history:", err);
-                    }
+                }
+                if (signalingError.code === 'screen_share_denied') {
+                    alert("Screen share denied: " + signalingError.message);
+                    releaseScreenShare();
                     break;
-                default:
-                    console.log("Unknown message type:", msg.event);
-            }
-        };
-        ws.onopen = () => {
-            updateStatus("Connected to room: " + currentRoom);
-        };
+                }
+                console.error("Signaling error:", signalingError);
+                break;
+            case 'chat':
+                addChatMessage(msg.sender, msg.text);
+                lastChatId = Math.max(lastChatId, msg.id || 0);
+                break;
+            case 'chat_history':
+                try {
+                    const history = JSON.parse(msg.data);
+                    history.forEach(item => {
+                        addChatMessage(item.sender, item.text, new Date(item.timestamp));
+                        lastChatId = Math.max(lastChatId, item.id || 0);
+                    });
+                } catch (err) {
+                    console.error("Error parsing chat history:", err);
+                }
+                break;
+            default:
+                console.log("Unknown message type:", msg.event);
+        }
+    };
+    ws.onopen = () => {
+        reconnectDeadline = 0;
+        updateStatus("Connected to room: " + currentRoom);
+    };
+}
+
+// Send our own offer, e.g. when local tracks change; the server answers with 'answer'
+function sendOffer() {
+    if (!pc || !ws || ws.readyState !== WebSocket.OPEN) {
+        return;
+    }
+    if (pc.signalingState !== 'stable') {
+        offerQueued = true;
+        return;
+    }
+    makingOffer = true;
+    pc.setLocalDescription().then(() => {
+        ws.send(JSON.stringify({
+            event: 'offer',
+            data: JSON.stringify(pc.localDescription)
+        }));
     }).catch(err => {
-        updateStatus("Media access error");
-        alert("Error accessing media devices: " + err.message);
-        console.error("Media error:", err);
+        console.error("Error creating offer:", err);
+    }).finally(() => {
+        makingOffer = false;
     });
 }
 
+// Reconnect within the server's grace period, keeping the existing PeerConnection
+function scheduleReconnect() {
+    if (!reconnectDeadline) {
+        reconnectDeadline = Date.now() + resumeTimeout * 1000;
+    }
+    if (Date.now() > reconnectDeadline) {
+        reconnectDeadline = 0;
+        leaveRoom();
+        updateStatus("Connection lost");
+        return;
+    }
+    updateStatus("Reconnecting...");
+    setTimeout(() => {
+        if (leaving || !sessionToken) return;
+        openSignaling(`${baseWsURL}&resume=${encodeURIComponent(sessionToken)}&last_chat=${lastChatId}`);
+    }, 1000);
+}
+
 function addChatMessage(sender, text, timestamp = new Date()) {
     const chatDiv = document.getElementById('chatMessages');
     const messageDiv = document.createElement('div');
@@ -261,6 +493,99 @@ function sendChatMessage() {
     document.getElementById('chatInput').value = '';
 }
 
+// Enable or disable the local camera/microphone and let the room know
+function toggleTrack(kind) {
+    if (!localStream) return;
+    const track = kind === 'video' ? localStream.getVideoTracks()[0] : localStream.getAudioTracks()[0];
+    if (!track) return;
+    track.enabled = !track.enabled;
+    const button = document.getElementById(kind === 'video' ? 'cameraBtn' : 'microphoneBtn');
+    button.classList.toggle('off', !track.enabled);
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify({
+            event: track.enabled ? 'track_unmute' : 'track_mute',
+            data: JSON.stringify({
+                track_id: track.id
+            })
+        }));
+    }
+}
+
+function toggleScreenShare() {
+    if (screenStream) {
+        stopScreenShare();
+        return;
+    }
+    if (!ws || ws.readyState !== WebSocket.OPEN) return;
+    navigator.mediaDevices.getDisplayMedia({
+        video: true
+    }).then(stream => {
+        screenStream = stream;
+        stream.getVideoTracks()[0].onended = () => stopScreenShare();
+        document.getElementById('screenBtn').classList.add('off');
+        // The server answers with a renegotiation offering a new recvonly video m-line
+        ws.send(JSON.stringify({
+            event: 'screen_share_start'
+        }));
+    }).catch(err => {
+        console.error("Screen share error:", err);
+    });
+}
+
+function stopScreenShare() {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+        ws.send(JSON.stringify({
+            event: 'screen_share_stop'
+        }));
+    }
+    releaseScreenShare();
+}
+
+function releaseScreenShare() {
+    if (screenStream) {
+        screenStream.getTracks().forEach(track => track.stop());
+    }
+    screenStream = null;
+    screenTransceiver = null;
+    document.getElementById('screenBtn').classList.remove('off');
+}
+
+// Attach the pending screen track to the video m-line the server offered for receiving
+function attachScreenTrack(offer) {
+    if (!screenStream || screenTransceiver) return;
+    offer.sdp.split('\r\nm=').slice(1).forEach(section => {
+        if (screenTransceiver || !section.startsWith('video') || section.indexOf('a=recvonly') === -1) return;
+        const mid = (section.match(/a=mid:(\S+)/) || [])[1];
+        const transceiver = pc.getTransceivers().find(t => t.mid === mid && !t.sender.track);
+        if (!transceiver) return;
+        transceiver.direction = 'sendonly';
+        transceiver.sender.replaceTrack(screenStream.getVideoTracks()[0]);
+        if (transceiver.sender.setStreams) {
+            transceiver.sender.setStreams(screenStream);
+        }
+        screenTransceiver = transceiver;
+    });
+}
+
+function participantForTrack(trackId) {
+    return Object.values(participants).find(p => (p.track_ids || []).includes(trackId));
+}
+
+// Send an app message (whiteboard, cursors) to the room over a data channel.
+// Without `to` the message is broadcast; `reliable: false` uses the lossy channel.
+function sendAppMessage(payload, options = {}) {
+    const label = options.reliable === false ? 'unreliable' : 'reliable';
+    const channel = dataChannels[label];
+    if (!channel || channel.readyState !== 'open') {
+        return false;
+    }
+    channel.send(JSON.stringify({
+        to: options.to || undefined,
+        payload: payload
+    }));
+    return true;
+}
+
 function updateStatus(message) {
     document.getElementById('statusBar').textContent = message;
 }
@@ -0,0 +1,68 @@
+package static
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// scriptHarness загружает script.js в node с заглушками DOM и WebSocket, открывает сигнализацию,
+// передаёт клиенту событие из argv[2] и печатает location.href и вызовы alert
+const scriptHarness = `
+const vm = require('vm'), fs = require('fs');
+const element = () => ({style: {}, children: [], dataset: {}, classList: {toggle() {}}, addEventListener() {}, appendChild() {}, removeChild() {}});
+const alerts = [];
+class WebSocket {
+    static OPEN = 1;
+    constructor(url) { this.url = url; this.readyState = WebSocket.OPEN; }
+    close() { this.readyState = 3; }
+    send() {}
+}
+const context = {
+    window: {addEventListener() {}}, document: {getElementById: element, createElement: element, body: element()},
+    location: {}, WebSocket, console, alert: message => alerts.push(message), setTimeout() {},
+};
+vm.createContext(context);
+vm.runInContext(fs.readFileSync(process.argv[1], 'utf8'), context);
+vm.runInContext("currentRoom = 'meeting'; sessionToken = 'token'; openSignaling('ws://node-a/websocket?room=meeting')", context);
+vm.runInContext('ws.onmessage({data: ' + JSON.stringify(process.argv[2]) + '})', context);
+console.log(JSON.stringify({href: context.location.href || '', alerts}));
+`
+
+// Клиент покидает комнату без исключения и продолжает обработку события: переходит на другой узел
+// при server_draining и сообщает об обрыве при session_expired
+func TestScriptLeavesRoom(t *testing.T) {
+	node, err := exec.LookPath("node")
+	if err != nil {
+		t.Skip("node is not installed")
+	}
+
+	tests := []struct {
+		name  string
+		event string
+		want  string
+	}{
+		{
+			name:  "server_draining redirect",
+			event: `{"event":"server_draining","data":"{\"redirect\":\"https://node-b\",\"deadline\":\"2026-01-01T00:00:00Z\"}"}`,
+			want:  `{"href":"https://node-b/?room=meeting","alerts":[]}`,
+		},
+		{
+			name:  "session_expired",
+			event: `{"event":"error","data":"{\"code\":\"session_expired\",\"message\":\"session expired\"}"}`,
+			want:  `{"href":"","alerts":["Connection lost, please join the room again"]}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			output, err := exec.Command(node, "-e", scriptHarness, "script.js", test.event).CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v: %s", err, output)
+			}
+			if got := strings.TrimSpace(string(output)); got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
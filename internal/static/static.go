@@ -0,0 +1,201 @@
+package static
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Веб-клиент встроен в бинарник, поэтому сервер не зависит от рабочего каталога.
+// Файлы отдаются с ETag, а по адресам с хешем содержимого (style.1a2b3c4d.css) — с долгим кешированием.
+
+//go:embed index.html style.css script.js
+var embedded embed.FS
+
+// Файлы, без которых клиент не работает
+var required = []string{"index.html", "style.css", "script.js"}
+
+const (
+	// Длина хеша содержимого в имени файла и ETag, в hex-символах
+	hashLength = 16
+
+	cacheImmutable   = "public, max-age=31536000, immutable"
+	cacheRevalidate  = "no-cache"
+	indexTemplateKey = "index.html"
+)
+
+// asset — файл клиента в памяти
+type asset struct {
+	name    string // Имя для определения Content-Type
+	content []byte
+	etag    string
+	hashed  bool // Адрес содержит хеш, содержимое по нему не меняется
+}
+
+// Assets — файлы веб-клиента: встроенные, поверх которых лежат файлы из каталога переопределения
+type Assets struct {
+	files  map[string]*asset // По пути запроса без ведущего "/", с хешем и без
+	hashed map[string]string // Имя файла → путь с хешем
+	index  *template.Template
+}
+
+// Load загружает встроенные файлы и файлы из overrideDir, если он задан.
+// Файлы каталога заменяют встроенные с тем же именем и добавляют новые (картинки, шрифты и т.п.).
+func Load(overrideDir string) (*Assets, error) {
+	sources := map[string][]byte{}
+
+	if err := readFiles(embedded, sources); err != nil {
+		return nil, err
+	}
+
+	if overrideDir != "" {
+		info, err := os.Stat(overrideDir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", overrideDir)
+		}
+
+		if err := readFiles(os.DirFS(overrideDir), sources); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range required {
+		if len(sources[name]) == 0 {
+			return nil, fmt.Errorf("static asset %s is missing or empty", name)
+		}
+	}
+
+	a := &Assets{
+		files:  make(map[string]*asset, 2*len(sources)),
+		hashed: make(map[string]string, len(sources)),
+	}
+
+	for name, content := range sources {
+		hash := contentHash(content)
+		etag := `"` + hash + `"`
+
+		a.files[name] = &asset{name: name, content: content, etag: etag}
+		if name == indexTemplateKey {
+			continue
+		}
+
+		hashedName := hashedPath(name, hash)
+		a.files[hashedName] = &asset{name: name, content: content, etag: etag, hashed: true}
+		a.hashed[name] = hashedName
+	}
+
+	index, err := template.New(indexTemplateKey).Funcs(template.FuncMap{"asset": a.URL}).Parse(string(sources[indexTemplateKey]))
+	if err != nil {
+		return nil, err
+	}
+	a.index = index
+
+	return a, nil
+}
+
+// readFiles читает все обычные файлы fsys в sources, пути — через "/".
+// Скрытые файлы и каталоги (.env, .git и т.п.) пропускаются, чтобы не раздавать их из HTTP_STATIC_DIR.
+func readFiles(fsys fs.FS, sources map[string][]byte) error {
+	return fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if name != "." && strings.HasPrefix(entry.Name(), ".") {
+			if entry.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		sources[name] = content
+
+		return nil
+	})
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// hashedPath вставляет хеш перед расширением: style.css → style.<hash>.css
+func hashedPath(name, hash string) string {
+	ext := path.Ext(name)
+	return strings.TrimSuffix(name, ext) + "." + hash + ext
+}
+
+// URL возвращает адрес файла с хешем содержимого, в шаблоне index.html — {{asset "style.css"}}
+func (a *Assets) URL(name string) string {
+	if hashedName, ok := a.hashed[name]; ok {
+		return "/" + hashedName
+	}
+
+	return "/" + name
+}
+
+// Handler отдаёт index.html на "/" и файлы клиента по остальным путям.
+// indexData возвращает данные шаблона index.html для запроса.
+func (a *Assets) Handler(indexData func(r *http.Request) any) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if r.URL.Path == "/" {
+			a.serveIndex(w, r, indexData(r))
+			return
+		}
+
+		file, ok := a.files[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok || file.name == indexTemplateKey {
+			http.NotFound(w, r)
+			return
+		}
+
+		if file.hashed {
+			w.Header().Set("Cache-Control", cacheImmutable)
+		} else {
+			w.Header().Set("Cache-Control", cacheRevalidate)
+		}
+		w.Header().Set("ETag", file.etag)
+
+		http.ServeContent(w, r, file.name, time.Time{}, bytes.NewReader(file.content))
+	}
+}
+
+// serveIndex отдаёт страницу, собранную по шаблону. Страница всегда перепроверяется,
+// чтобы после обновления клиента браузер получил новые адреса файлов.
+func (a *Assets) serveIndex(w http.ResponseWriter, r *http.Request, data any) {
+	var page bytes.Buffer
+	if err := a.index.Execute(&page, data); err != nil {
+		slog.Error("Failed to execute template", "err", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", cacheRevalidate)
+	w.Header().Set("ETag", `"`+contentHash(page.Bytes())+`"`)
+
+	http.ServeContent(w, r, indexTemplateKey, time.Time{}, bytes.NewReader(page.Bytes()))
+}
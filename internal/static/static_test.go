@@ -0,0 +1,111 @@
+package static
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Скрытые файлы и каталоги из HTTP_STATIC_DIR не попадают в раздачу
+func TestReadFilesSkipsHidden(t *testing.T) {
+	fsys := fstest.MapFS{
+		"logo.png":        {Data: []byte("png")},
+		"fonts/main.woff": {Data: []byte("woff")},
+		".env":            {Data: []byte("SECRET=1")},
+		".git/config":     {Data: []byte("[core]")},
+		"fonts/.htaccess": {Data: []byte("deny")},
+	}
+
+	sources := map[string][]byte{}
+	if err := readFiles(fsys, sources); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sources) != 2 || sources["logo.png"] == nil || sources["fonts/main.woff"] == nil {
+		t.Fatalf("unexpected files %v", keys(sources))
+	}
+}
+
+func keys(sources map[string][]byte) []string {
+	names := make([]string, 0, len(sources))
+	for name := range sources {
+		names = append(names, name)
+	}
+
+	return names
+}
+
+// Файлы HTTP_STATIC_DIR заменяют встроенные, отдаются с ETag и по адресам с хешем, отсутствующие — 404
+func TestHandler(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"style.css": "body{}", "logo.png": "png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assets, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := assets.Handler(func(*http.Request) any { return "wss://example.com/websocket" })
+
+	styleETag := `"` + contentHash([]byte("body{}")) + `"`
+	hashedStyle := assets.URL("style.css")
+	if hashedStyle != "/"+hashedPath("style.css", contentHash([]byte("body{}"))) {
+		t.Fatalf("style.css URL %s does not carry the override's hash", hashedStyle)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		ifNoneMatch string
+		status      int
+		body        string // Подстрока тела ответа
+		cache       string
+	}{
+		{name: "override replaces embedded file", path: "/style.css", status: http.StatusOK, body: "body{}", cache: cacheRevalidate},
+		{name: "override adds new file", path: "/logo.png", status: http.StatusOK, body: "png", cache: cacheRevalidate},
+		{name: "embedded file", path: "/script.js", status: http.StatusOK, body: "leaveRoom", cache: cacheRevalidate},
+		{name: "hashed URL is immutable", path: hashedStyle, status: http.StatusOK, body: "body{}", cache: cacheImmutable},
+		{name: "matching ETag", path: "/style.css", ifNoneMatch: styleETag, status: http.StatusNotModified},
+		{name: "stale ETag", path: "/style.css", ifNoneMatch: `"stale"`, status: http.StatusOK, body: "body{}"},
+		{name: "index links hashed assets", path: "/", status: http.StatusOK, body: hashedStyle, cache: cacheRevalidate},
+		{name: "index with websocket URL", path: "/", status: http.StatusOK, body: `data-ws-url="wss://example.com/websocket"`},
+		{name: "index template is not served raw", path: "/index.html", status: http.StatusNotFound},
+		{name: "missing file", path: "/missing.js", status: http.StatusNotFound},
+		{name: "outdated hash", path: "/style.0000000000000000.css", status: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodPost, path: "/style.css", status: http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			request := httptest.NewRequest(method, test.path, nil)
+			if test.ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", test.ifNoneMatch)
+			}
+
+			recorder := httptest.NewRecorder()
+			handler(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Fatalf("status %d, want %d", recorder.Code, test.status)
+			}
+			if !strings.Contains(recorder.Body.String(), test.body) {
+				t.Fatalf("body does not contain %q", test.body)
+			}
+			if test.cache != "" && recorder.Header().Get("Cache-Control") != test.cache {
+				t.Fatalf("Cache-Control %q, want %q", recorder.Header().Get("Cache-Control"), test.cache)
+			}
+		})
+	}
+}
@@ -6,13 +6,12 @@ import (
 	"net/http"
 )
 
-const checkTokenURL = "http://77.222.53.150/api/check_token/"
-
 type TokenResponse struct {
 	Success string `json:"success"`
 }
 
-func ValidateToken(token string) (bool, error) {
+// ValidateToken проверяет токен на сервере авторизации checkTokenURL (AUTH_TOKEN_URL)
+func ValidateToken(checkTokenURL, token string) (bool, error) {
 	req, err := http.NewRequest("GET", checkTokenURL, nil)
 	if err != nil {
 		return false, fmt.Errorf("ошибка при создании запроса: %w", err)
@@ -43,7 +42,7 @@ func ValidateToken(token string) (bool, error) {
 
 // func main() {
 // 	token := "Token 4b4d65e2c6987c60be6231febe98a064b7167ae4" // замените на реальный токен
-// 	valid, err := ValidateToken(token)
+// 	valid, err := ValidateToken("http://77.222.53.150/api/check_token/", token)
 // 	if err != nil {
 // 		fmt.Println("Ошибка валидации:", err)
 // 		return
@@ -0,0 +1,110 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader отдаёт сертификат для tls.Config.GetCertificate и перечитывает его,
+// когда файлы сертификата или ключа меняются на диске (например, после продления certbot).
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time // Время изменения файлов загруженного сертификата
+}
+
+// New загружает сертификат и ключ, ошибка — если пара некорректна
+func New(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetCertificate возвращает текущий сертификат
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// Run проверяет файлы каждые interval до отмены ctx. Если новая пара не загружается
+// (например, ключ ещё не дописан), остаётся прежний сертификат и попытка повторяется.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			modTime, err := r.filesModTime()
+			if err != nil {
+				slog.Warn("Failed to stat TLS certificate", "err", err)
+				continue
+			}
+
+			r.mu.RLock()
+			changed := !modTime.Equal(r.modTime)
+			r.mu.RUnlock()
+
+			if !changed {
+				continue
+			}
+
+			if err := r.load(); err != nil {
+				slog.Error("Failed to reload TLS certificate", "err", err)
+				continue
+			}
+
+			slog.Info("Reloaded TLS certificate", "file", r.certFile)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Reloader) load() error {
+	// Время изменения берётся до чтения: запись во время загрузки приведёт к повторной загрузке
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.modTime = modTime
+	r.mu.Unlock()
+
+	return nil
+}
+
+// filesModTime возвращает самое позднее время изменения сертификата и ключа
+func (r *Reloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}